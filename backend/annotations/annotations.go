@@ -0,0 +1,193 @@
+package annotations
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// Highlights are anchored with a text quote selector: the highlighted text
+// plus some context on either side, measured against the plain text of the
+// article. Offsets are only a hint, so a highlight survives markup changes
+// (re-sanitizing, <b> becoming <strong>, whitespace) as long as the quoted
+// text is still there.
+
+// contextLength is the number of characters of prefix and suffix stored
+const contextLength = 32
+
+// Selector is a text quote anchor. Start and End are rune offsets into the
+// plain text of the article.
+type Selector struct {
+	Exact  string
+	Prefix string
+	Suffix string
+	Start  int
+	End    int
+}
+
+// PlainText returns the visible text of article HTML with whitespace
+// collapsed, which is what selectors are measured against
+func PlainText(content string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(content))
+	skip := 0
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			// io.EOF, or malformed HTML in which case what was read so far is kept
+			return collapse(b.String())
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if tag == "script" || tag == "style" {
+				if tt == html.StartTagToken {
+					skip++
+				} else if skip > 0 {
+					skip--
+				}
+			}
+			if blockTags[tag] {
+				b.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// blockTags separate words even without whitespace between them in the markup
+var blockTags = map[string]bool{
+	"p": true, "br": true, "div": true, "li": true, "ul": true, "ol": true, "blockquote": true,
+	"pre": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "img": true,
+	"tr": true, "td": true, "th": true,
+}
+
+// collapse trims s and replaces every run of whitespace with a single space
+func collapse(s string) string {
+	return strings.Join(strings.FieldsFunc(s, unicode.IsSpace), " ")
+}
+
+// Describe builds a selector for the runes [start, end) of text
+func Describe(text string, start, end int) (Selector, error) {
+	runes := []rune(text)
+	if start < 0 || end > len(runes) || start >= end {
+		return Selector{}, fmt.Errorf("range %d-%d is outside the article text (%d characters)", start, end, len(runes))
+	}
+
+	return Selector{
+		Exact:  string(runes[start:end]),
+		Prefix: string(runes[max(0, start-contextLength):start]),
+		Suffix: string(runes[end:min(len(runes), end+contextLength)]),
+		Start:  start,
+		End:    end,
+	}, nil
+}
+
+// Complete fills in the context and offsets of a selector that only has its
+// quote (and optionally context) set, as sent by clients that anchor by text.
+// The quote must be found in text.
+func Complete(text string, sel Selector) (Selector, error) {
+	sel.Exact = collapse(sel.Exact)
+	if sel.Exact == "" {
+		return Selector{}, fmt.Errorf("exact is required")
+	}
+
+	start, end, ok := Locate(text, sel)
+	if !ok {
+		return Selector{}, fmt.Errorf("highlighted text not found in article")
+	}
+
+	described, err := Describe(text, start, end)
+	if err != nil {
+		return Selector{}, err
+	}
+	// Keep context the client sent; it may be longer than ours
+	if sel.Prefix != "" {
+		described.Prefix = sel.Prefix
+	}
+	if sel.Suffix != "" {
+		described.Suffix = sel.Suffix
+	}
+	return described, nil
+}
+
+// Locate finds a selector in text and returns its current rune offsets.
+// Every occurrence of the quote is scored by how much of the prefix and
+// suffix still surround it; ties go to the occurrence closest to the stored
+// offsets. ok is false when the quote no longer appears at all.
+func Locate(text string, sel Selector) (start, end int, ok bool) {
+	exact := []rune(collapse(sel.Exact))
+	if len(exact) == 0 {
+		return 0, 0, false
+	}
+	runes := []rune(text)
+	prefix := []rune(collapse(sel.Prefix))
+	suffix := []rune(collapse(sel.Suffix))
+
+	bestScore, bestDistance := -1, 0
+	for i := 0; i+len(exact) <= len(runes); i++ {
+		if !slices.Equal(runes[i:i+len(exact)], exact) {
+			continue
+		}
+
+		score := commonSuffix(runes[:i], prefix) + commonPrefix(runes[i+len(exact):], suffix)
+		distance := abs(i - sel.Start)
+		if score > bestScore || (score == bestScore && distance < bestDistance) {
+			bestScore, bestDistance = score, distance
+			start, end, ok = i, i+len(exact), true
+		}
+	}
+	return start, end, ok
+}
+
+// commonSuffix is the number of trailing runes text shares with prefix,
+// ignoring a space at the boundary
+func commonSuffix(text, prefix []rune) int {
+	text = trimRight(text)
+	prefix = trimRight(prefix)
+	n := 0
+	for n < len(text) && n < len(prefix) && text[len(text)-1-n] == prefix[len(prefix)-1-n] {
+		n++
+	}
+	return n
+}
+
+// commonPrefix is the number of leading runes text shares with suffix,
+// ignoring a space at the boundary
+func commonPrefix(text, suffix []rune) int {
+	text = trimLeft(text)
+	suffix = trimLeft(suffix)
+	n := 0
+	for n < len(text) && n < len(suffix) && text[n] == suffix[n] {
+		n++
+	}
+	return n
+}
+
+func trimRight(r []rune) []rune {
+	for len(r) > 0 && r[len(r)-1] == ' ' {
+		r = r[:len(r)-1]
+	}
+	return r
+}
+
+func trimLeft(r []rune) []rune {
+	for len(r) > 0 && r[0] == ' ' {
+		r = r[1:]
+	}
+	return r
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,143 @@
+package annotations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// runeIndex returns the rune offset of the nth (from 0) occurrence of sub in
+// text
+func runeIndex(t *testing.T, text, sub string, nth int) int {
+	t.Helper()
+	offset := 0
+	for range nth {
+		i := strings.Index(text[offset:], sub)
+		if i < 0 {
+			t.Fatalf("%q occurs fewer than %d times in %q", sub, nth+1, text)
+		}
+		offset += i + len(sub)
+	}
+	i := strings.Index(text[offset:], sub)
+	if i < 0 {
+		t.Fatalf("%q occurs fewer than %d times in %q", sub, nth+1, text)
+	}
+	return len([]rune(text[:offset+i]))
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"inline markup", "<p>Hello <b>world</b></p>", "Hello world"},
+		{"block tags separate words", "<p>one</p><p>two</p><ul><li>three</li><li>four</li></ul>", "one two three four"},
+		{"whitespace collapsed", "<p>  a \n\t b  </p>", "a b"},
+		{"scripts and styles dropped", "<p>a</p><script>var x = 1;</script><style>p{}</style><p>b</p>", "a b"},
+		{"entities decoded", "<p>fish &amp; chips</p>", "fish & chips"},
+		{"malformed", "<p>kept <b", "kept"},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PlainText(tt.content); got != tt.want {
+				t.Errorf("PlainText(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	text := "Zürich is a city in Switzerland"
+	sel, err := Describe(text, 0, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.Exact != "Zürich" || sel.Prefix != "" || sel.Suffix != " is a city in Switzerland" {
+		t.Errorf("Describe() = %+v", sel)
+	}
+
+	for _, r := range [][2]int{{-1, 2}, {2, 2}, {3, 1}, {0, 100}} {
+		if _, err := Describe(text, r[0], r[1]); err == nil {
+			t.Errorf("Describe(%d, %d) accepted a range outside the text", r[0], r[1])
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	text := "The cat sat on the mat. Later the cat ran away from the dog."
+	tests := []struct {
+		name      string
+		text      string
+		sel       Selector
+		wantStart int
+		wantOK    bool
+	}{
+		{"unique quote", text, Selector{Exact: "mat"}, runeIndex(t, text, "mat", 0), true},
+		{"prefix picks occurrence", text, Selector{Exact: "cat", Prefix: "Later the "}, runeIndex(t, text, "cat", 1), true},
+		{"suffix picks occurrence", text, Selector{Exact: "the", Suffix: " dog."}, runeIndex(t, text, "the", 2), true},
+		{"offset breaks ties", text, Selector{Exact: "the", Start: runeIndex(t, text, "the", 1)}, runeIndex(t, text, "the", 1), true},
+		{"context beats offset", text, Selector{Exact: "cat", Prefix: "Later the ", Start: 0}, runeIndex(t, text, "cat", 1), true},
+		{"quote whitespace collapsed", text, Selector{Exact: "sat \n on"}, runeIndex(t, text, "sat on", 0), true},
+		{"text moved", "Breaking: " + text, Selector{Exact: "cat", Prefix: "Later the ", Start: runeIndex(t, text, "cat", 1)},
+			runeIndex(t, "Breaking: "+text, "cat", 1), true},
+		{"context partly edited", strings.Replace(text, "Later", "Then", 1), Selector{Exact: "cat", Prefix: "Later the ", Suffix: " ran"},
+			runeIndex(t, strings.Replace(text, "Later", "Then", 1), "cat", 1), true},
+		{"quote removed", text, Selector{Exact: "bird"}, 0, false},
+		{"empty quote", text, Selector{Exact: "  "}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := Locate(tt.text, tt.sel)
+			if ok != tt.wantOK {
+				t.Fatalf("Locate() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if start != tt.wantStart {
+				t.Errorf("Locate() start = %d, want %d", start, tt.wantStart)
+			}
+			if got := string([]rune(tt.text)[start:end]); got != collapse(tt.sel.Exact) {
+				t.Errorf("Locate() found %q, want %q", got, collapse(tt.sel.Exact))
+			}
+		})
+	}
+}
+
+func TestResolveReanchorsEditedArticle(t *testing.T) {
+	original := "<p>Hello <b>world</b>, this is a test.</p><p>Another world entirely.</p>"
+	text := PlainText(original)
+	second, err := Describe(text, runeIndex(t, text, "world", 1), runeIndex(t, text, "world", 1)+len("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := Describe(text, runeIndex(t, text, "test", 0), runeIndex(t, text, "test", 0)+len("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	highlight := func(sel Selector) db.Annotation {
+		return db.Annotation{Kind: db.AnnotationHighlight, Exact: sel.Exact, Prefix: sel.Prefix, Suffix: sel.Suffix, Start: sel.Start, End: sel.End}
+	}
+	list := []db.Annotation{highlight(second), highlight(gone), {Kind: db.AnnotationNote, Start: 3, End: 4}}
+
+	// Re-sanitized markup, an added paragraph and a rewritten sentence
+	edited := &db.Article{Description: "<p>Update: corrected.</p><p>Hello <strong>world</strong>, this is an example.</p><p>Another world entirely.</p>"}
+	Resolve(edited, list)
+
+	editedText := PlainText(edited.Description)
+	if want := runeIndex(t, editedText, "world", 1); list[0].Orphaned || list[0].Start != want || list[0].End != want+len("world") {
+		t.Errorf("highlight = %d-%d orphaned %v, want %d-%d", list[0].Start, list[0].End, list[0].Orphaned, want, want+len("world"))
+	}
+	if !list[1].Orphaned {
+		t.Errorf("highlight of removed text isn't orphaned")
+	}
+	if list[2].Orphaned || list[2].Start != 3 {
+		t.Errorf("note was re-anchored: %+v", list[2])
+	}
+}
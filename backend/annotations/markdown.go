@@ -0,0 +1,56 @@
+package annotations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// Resolve re-anchors stored highlights against the article's current text,
+// updating their offsets and marking the ones that can no longer be found
+func Resolve(article *db.Article, annotations []db.Annotation) {
+	text := PlainText(article.Description)
+	for i := range annotations {
+		a := &annotations[i]
+		if a.Kind != db.AnnotationHighlight {
+			continue
+		}
+		start, end, ok := Locate(text, Selector{Exact: a.Exact, Prefix: a.Prefix, Suffix: a.Suffix, Start: a.Start, End: a.End})
+		if ok {
+			a.Start, a.End = start, end
+		}
+		a.Orphaned = !ok
+	}
+}
+
+// Markdown renders an article's annotations as a Markdown document:
+// highlights as block quotes followed by their note, standalone notes as
+// paragraphs
+func Markdown(article *db.Article, annotations []db.Annotation) string {
+	var b strings.Builder
+
+	title := article.Title
+	if title == "" {
+		title = fmt.Sprintf("Article %d", article.ID)
+	}
+	fmt.Fprintf(&b, "# %s\n\n", collapse(title))
+	if article.Link != "" {
+		fmt.Fprintf(&b, "<%s>\n\n", article.Link)
+	}
+
+	for _, a := range annotations {
+		if a.Kind == db.AnnotationHighlight {
+			fmt.Fprintf(&b, "> %s\n", collapse(a.Exact))
+			if a.Orphaned {
+				b.WriteString(">\n> _(no longer found in the article)_\n")
+			}
+			b.WriteString("\n")
+		}
+		if note := strings.TrimSpace(a.Note); note != "" {
+			b.WriteString(note)
+			b.WriteString("\n\n")
+		}
+	}
+	return b.String()
+}
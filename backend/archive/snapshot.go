@@ -0,0 +1,395 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// A snapshot is the page's HTML with stylesheets and images inlined (as
+// <style> elements and data: URIs) and scripts, frames and plugins removed,
+// so it renders the same without any network access.
+
+const (
+	maxPageBytes     = 10 << 20
+	maxResourceBytes = 5 << 20
+	// maxInlineBytes caps everything inlined into one snapshot. Resources
+	// past the budget keep their original URL.
+	maxInlineBytes = 50 << 20
+	maxImportDepth = 3
+	snapshotTime   = 2 * time.Minute
+)
+
+// contentPolicy keeps a snapshot from loading anything that wasn't inlined
+const contentPolicy = "default-src 'none'; img-src data:; style-src 'unsafe-inline'; font-src data:; media-src data:"
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// removed are elements that run code or load remote content
+var removed = map[atom.Atom]bool{
+	atom.Script: true, atom.Iframe: true, atom.Frame: true, atom.Frameset: true, atom.Object: true,
+	atom.Embed: true, atom.Applet: true, atom.Base: true, atom.Source: true, atom.Template: true,
+}
+
+// lazySrc are attributes lazy loading scripts keep the real image URL in
+var lazySrc = []string{"data-src", "data-lazy-src", "data-original", "data-url"}
+
+type snapshotter struct {
+	ctx     context.Context
+	budget  int64
+	inlined map[string]string // Resource URL to data: URI
+}
+
+// Snapshot fetches a page and returns a self-contained copy of it
+func Snapshot(ctx context.Context, pageURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTime)
+	defer cancel()
+
+	response, err := fetch(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	contentType := response.Header.Get("Content-Type")
+	if contentType != "" && !strings.Contains(contentType, "html") {
+		return nil, fmt.Errorf("%s is not an HTML page (%s)", pageURL, contentType)
+	}
+
+	reader, err := charset.NewReader(io.LimitReader(response.Body, maxPageBytes), contentType)
+	if err != nil {
+		return nil, err
+	}
+	// With scripting off, <noscript> content is parsed as markup. It's
+	// usually the non-lazy version of images.
+	doc, err := html.ParseWithOptions(reader, html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &snapshotter{ctx: ctx, budget: maxInlineBytes, inlined: map[string]string{}}
+	s.rewrite(doc, response.Request.URL)
+	addMeta(doc, response.Request.URL.String())
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func fetch(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", rawURL, response.Status)
+	}
+	return response, nil
+}
+
+// rewrite inlines the resources of n's descendants and removes the
+// elements a snapshot can't keep
+func (s *snapshotter) rewrite(n *html.Node, base *url.URL) {
+	var next *html.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+		if c.Type != html.ElementNode {
+			continue
+		}
+
+		switch {
+		case removed[c.DataAtom]:
+			n.RemoveChild(c)
+			continue
+		case c.DataAtom == atom.Noscript:
+			s.rewrite(c, base)
+			for c.FirstChild != nil {
+				child := c.FirstChild
+				c.RemoveChild(child)
+				n.InsertBefore(child, c)
+			}
+			n.RemoveChild(c)
+			continue
+		case c.DataAtom == atom.Link:
+			if style := s.stylesheet(c, base); style != nil {
+				n.InsertBefore(style, c)
+			}
+			n.RemoveChild(c)
+			continue
+		case c.DataAtom == atom.Meta && (getAttr(c, "http-equiv") != "" || getAttr(c, "charset") != ""):
+			// Refreshes, policies and charsets (the snapshot is UTF-8) are
+			// replaced by addMeta
+			n.RemoveChild(c)
+			continue
+		case c.DataAtom == atom.Style:
+			if c.FirstChild != nil && c.FirstChild.Type == html.TextNode {
+				c.FirstChild.Data = s.inlineCSS(c.FirstChild.Data, base, 0)
+			}
+		case c.DataAtom == atom.Img:
+			s.image(c, base)
+		case c.DataAtom == atom.A || c.DataAtom == atom.Area:
+			if href := getAttr(c, "href"); href != "" {
+				setAttr(c, "href", resolve(base, href))
+			}
+		}
+
+		s.attributes(c, base)
+		s.rewrite(c, base)
+	}
+}
+
+// attributes drops event handlers and inlines url()s in style attributes
+func (s *snapshotter) attributes(n *html.Node, base *url.URL) {
+	kept := n.Attr[:0]
+	for _, a := range n.Attr {
+		if strings.HasPrefix(strings.ToLower(a.Key), "on") {
+			continue
+		}
+		if a.Key == "style" {
+			a.Val = s.inlineCSS(a.Val, base, maxImportDepth)
+		}
+		kept = append(kept, a)
+	}
+	n.Attr = kept
+}
+
+// stylesheet turns a <link rel="stylesheet"> into a <style> element with
+// the stylesheet's content. Other links (preloads, icons, feeds) return nil.
+func (s *snapshotter) stylesheet(link *html.Node, base *url.URL) *html.Node {
+	if !strings.Contains(strings.ToLower(getAttr(link, "rel")), "stylesheet") {
+		return nil
+	}
+	href := resolve(base, getAttr(link, "href"))
+	css, sheetURL, err := s.text(href)
+	if err != nil {
+		return nil
+	}
+
+	style := &html.Node{Type: html.ElementNode, Data: "style", DataAtom: atom.Style}
+	if media := getAttr(link, "media"); media != "" {
+		setAttr(style, "media", media)
+	}
+	style.AppendChild(&html.Node{Type: html.TextNode, Data: s.inlineCSS(css, sheetURL, 0)})
+	return style
+}
+
+// image inlines an image, taking its URL from lazy loading attributes or
+// srcset when src is a placeholder
+func (s *snapshotter) image(img *html.Node, base *url.URL) {
+	src := getAttr(img, "src")
+	if src == "" || strings.HasPrefix(src, "data:") {
+		for _, attr := range lazySrc {
+			if v := getAttr(img, attr); v != "" {
+				src = v
+				break
+			}
+		}
+	}
+	if src == "" || strings.HasPrefix(src, "data:") {
+		srcset := getAttr(img, "srcset")
+		if srcset == "" {
+			srcset = getAttr(img, "data-srcset")
+		}
+		if fields := strings.Fields(strings.Split(srcset, ",")[0]); len(fields) > 0 {
+			src = fields[0]
+		}
+	}
+
+	for _, attr := range append([]string{"srcset", "data-srcset", "sizes"}, lazySrc...) {
+		removeAttr(img, attr)
+	}
+	if src == "" || strings.HasPrefix(src, "data:") {
+		return
+	}
+
+	src = resolve(base, src)
+	if data, ok := s.dataURI(src); ok {
+		src = data
+	}
+	setAttr(img, "src", src)
+}
+
+// cssImport matches @import rules; the URL is in one of the first three
+// groups and the media list in the fourth
+var cssImport = regexp.MustCompile(`@import\s+(?:url\(\s*)?(?:"([^"]*)"|'([^']*)'|([^\s;'")]+))\s*\)?([^;]*);`)
+
+// cssURL matches url() references; the URL is in one of the three groups
+var cssURL = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s]*))\s*\)`)
+
+// inlineCSS replaces @import rules with the imported stylesheet and url()
+// references with data: URIs. URLs are resolved against base, the URL of
+// the stylesheet.
+func (s *snapshotter) inlineCSS(css string, base *url.URL, depth int) string {
+	css = cssImport.ReplaceAllStringFunc(css, func(rule string) string {
+		m := cssImport.FindStringSubmatch(rule)
+		if depth >= maxImportDepth {
+			return ""
+		}
+		imported, importURL, err := s.text(resolve(base, m[1]+m[2]+m[3]))
+		if err != nil {
+			return ""
+		}
+		imported = s.inlineCSS(imported, importURL, depth+1)
+		if media := strings.TrimSpace(m[4]); media != "" {
+			return "@media " + media + " {\n" + imported + "\n}"
+		}
+		return imported
+	})
+
+	return cssURL.ReplaceAllStringFunc(css, func(ref string) string {
+		m := cssURL.FindStringSubmatch(ref)
+		target := m[1] + m[2] + m[3]
+		if target == "" || strings.HasPrefix(target, "data:") || strings.HasPrefix(target, "#") {
+			return ref
+		}
+		target = resolve(base, target)
+		if data, ok := s.dataURI(target); ok {
+			target = data
+		}
+		return `url("` + strings.ReplaceAll(target, `"`, `%22`) + `")`
+	})
+}
+
+// download fetches a resource within the remaining budget
+func (s *snapshotter) download(rawURL string) ([]byte, *http.Response, error) {
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		return nil, nil, fmt.Errorf("not an http(s) URL: %s", rawURL)
+	}
+	limit := min(int64(maxResourceBytes), s.budget)
+	if limit <= 0 {
+		return nil, nil, fmt.Errorf("snapshot size limit reached")
+	}
+
+	response, err := fetch(s.ctx, rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, nil, fmt.Errorf("%s is too large", rawURL)
+	}
+	s.budget -= int64(len(data))
+	return data, response, nil
+}
+
+// text downloads a stylesheet, returning it with its final URL
+func (s *snapshotter) text(rawURL string) (string, *url.URL, error) {
+	data, response, err := s.download(rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(data), response.Request.URL, nil
+}
+
+// dataURI downloads a resource and encodes it as a data: URI
+func (s *snapshotter) dataURI(rawURL string) (string, bool) {
+	if data, ok := s.inlined[rawURL]; ok {
+		return data, true
+	}
+
+	data, response, err := s.download(rawURL)
+	if err != nil {
+		return "", false
+	}
+	contentType, _, _ := strings.Cut(response.Header.Get("Content-Type"), ";")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+
+	uri := "data:" + strings.TrimSpace(contentType) + ";base64," + base64.StdEncoding.EncodeToString(data)
+	s.inlined[rawURL] = uri
+	return uri, true
+}
+
+// addMeta marks the document as UTF-8, blocks remote loads and records
+// where and when it was archived
+func addMeta(doc *html.Node, source string) {
+	head := find(doc, atom.Head)
+	if head == nil {
+		return
+	}
+
+	meta := []*html.Node{
+		{Type: html.ElementNode, Data: "meta", DataAtom: atom.Meta, Attr: []html.Attribute{{Key: "charset", Val: "utf-8"}}},
+		{Type: html.ElementNode, Data: "meta", DataAtom: atom.Meta, Attr: []html.Attribute{
+			{Key: "http-equiv", Val: "Content-Security-Policy"}, {Key: "content", Val: contentPolicy}}},
+		{Type: html.ElementNode, Data: "meta", DataAtom: atom.Meta, Attr: []html.Attribute{
+			{Key: "name", Val: "archived-from"}, {Key: "content", Val: source}}},
+		{Type: html.ElementNode, Data: "meta", DataAtom: atom.Meta, Attr: []html.Attribute{
+			{Key: "name", Val: "archived-at"}, {Key: "content", Val: time.Now().UTC().Format(time.RFC3339)}}},
+	}
+	for i := len(meta) - 1; i >= 0; i-- {
+		head.InsertBefore(meta[i], head.FirstChild)
+	}
+}
+
+func find(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := find(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func resolve(base *url.URL, ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Snapshots are kept in a blob store on disk. Blobs are named by the SHA-256
+// of their content and spread over subdirectories by the first two
+// characters of the name.
+
+// gracePeriod protects newly stored blobs from Sweep and Delete. A blob is
+// stored before the archive referring to it is saved, so a blob that no
+// archive refers to yet may be about to be used.
+const gracePeriod = time.Hour
+
+var dir string
+
+// validKey matches blob names, so a key can never point outside the store
+var validKey = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Init sets the directory snapshots are stored in, creating it if needed
+func Init(storeDir string) error {
+	if err := os.MkdirAll(storeDir, 0o755); err != nil {
+		return err
+	}
+	dir = storeDir
+	return nil
+}
+
+func blobPath(key string) (string, error) {
+	if !validKey.MatchString(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(dir, key[:2], key), nil
+}
+
+// Put stores a snapshot and returns its key. Storing the same content twice
+// keeps one copy, whose grace period starts over.
+func Put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	path, _ := blobPath(key)
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return key, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return key, nil
+}
+
+// Open opens a stored snapshot
+func Open(key string) (*os.File, error) {
+	path, err := blobPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Delete removes a stored snapshot. Deleting a missing one is not an error.
+// Snapshots stored within the grace period are left for Sweep.
+func Delete(key string) error {
+	path, err := blobPath(key)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if recent(info) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Sweep deletes every blob not in keep, such as the snapshots of deleted
+// articles, and returns how many were removed. Blobs and temporary files
+// written within the grace period are kept.
+func Sweep(keep map[string]bool) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		if keep[name] || (!validKey.MatchString(name) && !strings.HasPrefix(name, ".tmp")) {
+			return nil
+		}
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if recent(info) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+func recent(info fs.FileInfo) bool {
+	return time.Since(info.ModTime()) < gracePeriod
+}
@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSweepAndDeleteKeepRecentBlobs(t *testing.T) {
+	if err := Init(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := Put([]byte("old snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := Put([]byte("fresh snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reused, err := Put([]byte("reused snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	age := func(key string) {
+		path, _ := blobPath(key)
+		then := time.Now().Add(-2 * gracePeriod)
+		if err := os.Chtimes(path, then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+	age(old)
+	age(reused)
+	// Storing the same content again starts its grace period over
+	if _, err := Put([]byte("reused snapshot")); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := Sweep(map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Sweep removed %d blobs, want 1", removed)
+	}
+
+	exists := func(key string) bool {
+		path, _ := blobPath(key)
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	tests := []struct {
+		name string
+		key  string
+		want bool
+	}{
+		{"old", old, false},
+		{"fresh", fresh, true},
+		{"reused", reused, true},
+	}
+	for _, tt := range tests {
+		if got := exists(tt.key); got != tt.want {
+			t.Errorf("%s blob exists = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if err := Delete(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(fresh) {
+		t.Error("Delete removed a blob within its grace period")
+	}
+	age(fresh)
+	if err := Delete(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if exists(fresh) {
+		t.Error("Delete kept a blob past its grace period")
+	}
+	if err := Delete(fresh); err != nil {
+		t.Errorf("deleting a missing blob: %v", err)
+	}
+}
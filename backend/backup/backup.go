@@ -0,0 +1,241 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A backup is JSON lines: a header followed by one record per line and,
+// since version 2, an end record counting the records of each type, so a
+// truncated backup is detected. Records refer to each other by natural keys
+// (feed URL, category and tag name) rather than database IDs, so they can be
+// merged into any database.
+//
+//	{"type":"header","data":{"format":"go-reader-backup","version":2,"createdAt":"..."}}
+//	{"type":"category","data":{"name":"News","color":"#3b82f6"}}
+//	{"type":"feed","data":{"url":"https://example.com/rss","category":"News",...}}
+//	{"type":"article","data":{"feed":"https://example.com/rss","link":"...","read":true,...}}
+//	{"type":"end","data":{"category":1,"feed":1,"article":1}}
+
+const (
+	// Format identifies go-reader backups
+	Format = "go-reader-backup"
+	// Version is the version of the record layout written by this build.
+	// Readers accept backups up to this version.
+	Version = 2
+)
+
+// Record types, in the order they are written. Records may only refer to
+// records of an earlier type.
+const (
+	TypeHeader   = "header"
+	TypeCategory = "category"
+	TypeFeed     = "feed"
+	TypeTag      = "tag"
+	TypeWebhook  = "webhook"
+	TypeRule     = "rule"
+	TypeArticle  = "article"
+	TypeEnd      = "end" // Last record, its data is the Counts of the others
+)
+
+// maxLine is the longest record a backup may contain. Articles carry their
+// full content.
+const maxLine = 64 << 20
+
+// Header is the first line of a backup
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Counts is the number of records of each type in a backup
+type Counts map[string]int
+
+type record struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Writer writes the records of a backup
+type Writer struct {
+	enc    *json.Encoder
+	counts Counts
+}
+
+// NewWriter starts a backup on w by writing its header. The backup is only
+// complete once Close has written its end record.
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := &Writer{enc: json.NewEncoder(w), counts: Counts{}}
+	bw.enc.SetEscapeHTML(false)
+	header := Header{Format: Format, Version: Version, CreatedAt: time.Now().UTC()}
+	if err := bw.encode(TypeHeader, header); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+// Write adds a record of the given type
+func (w *Writer) Write(recordType string, v any) error {
+	if err := w.encode(recordType, v); err != nil {
+		return err
+	}
+	w.counts[recordType]++
+	return nil
+}
+
+// Close ends the backup with the number of records written of each type. It
+// doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	return w.encode(TypeEnd, w.counts)
+}
+
+func (w *Writer) encode(recordType string, v any) error {
+	return w.enc.Encode(struct {
+		Type string `json:"type"`
+		Data any    `json:"data"`
+	}{recordType, v})
+}
+
+// Reader reads the records of a backup, plain or gzip compressed
+type Reader struct {
+	Header Header
+	lines  *bufio.Scanner
+	line   int
+	counts Counts // Records read of each type
+	ended  bool
+}
+
+// NewReader checks the header of the backup in r
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	} else {
+		r = br
+	}
+
+	lines := bufio.NewScanner(r)
+	lines.Buffer(make([]byte, 0, 64*1024), maxLine)
+	reader := &Reader{lines: lines, counts: Counts{}}
+
+	recordType, data, err := reader.read()
+	if err == io.EOF {
+		return nil, errors.New("backup is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if recordType != TypeHeader {
+		return nil, errors.New("not a go-reader backup: missing header")
+	}
+	if err := json.Unmarshal(data, &reader.Header); err != nil {
+		return nil, fmt.Errorf("invalid backup header: %w", err)
+	}
+	if reader.Header.Format != Format {
+		return nil, fmt.Errorf("not a go-reader backup: format is %q", reader.Header.Format)
+	}
+	if reader.Header.Version < 1 || reader.Header.Version > Version {
+		return nil, fmt.Errorf("unsupported backup version %d (this build reads up to %d)", reader.Header.Version, Version)
+	}
+	return reader, nil
+}
+
+// Next returns the type and data of the next record, or io.EOF after the
+// last one. From version 2 on, a backup that ends without its end record,
+// or whose end record doesn't match the records read, is an error.
+func (r *Reader) Next() (string, json.RawMessage, error) {
+	if r.ended {
+		return "", nil, io.EOF
+	}
+
+	recordType, data, err := r.read()
+	if err == io.EOF {
+		if r.Header.Version >= 2 {
+			return "", nil, errors.New("backup is incomplete: no end record")
+		}
+		return "", nil, io.EOF
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch recordType {
+	case TypeHeader:
+		return "", nil, fmt.Errorf("line %d: unexpected header", r.line)
+	case TypeEnd:
+		if err := r.end(data); err != nil {
+			return "", nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return "", nil, io.EOF
+	}
+	r.counts[recordType]++
+	return recordType, data, nil
+}
+
+// end checks the end record against the records read and that nothing
+// follows it
+func (r *Reader) end(data json.RawMessage) error {
+	r.ended = true
+
+	var counts Counts
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return fmt.Errorf("invalid end record: %w", err)
+	}
+	for recordType, n := range counts {
+		if r.counts[recordType] != n {
+			return fmt.Errorf("backup is incomplete: %d %s records, expected %d", r.counts[recordType], recordType, n)
+		}
+	}
+	for recordType, n := range r.counts {
+		if _, ok := counts[recordType]; !ok {
+			return fmt.Errorf("backup has %d %s records its end record doesn't count", n, recordType)
+		}
+	}
+
+	if _, _, err := r.read(); err != io.EOF {
+		if err == nil {
+			err = errors.New("records after the end record")
+		}
+		return err
+	}
+	return nil
+}
+
+// read returns the next record, skipping blank lines
+func (r *Reader) read() (string, json.RawMessage, error) {
+	for r.lines.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.lines.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return "", nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if rec.Type == "" {
+			return "", nil, fmt.Errorf("line %d: record has no type", r.line)
+		}
+		return rec.Type, rec.Data, nil
+	}
+	if err := r.lines.Err(); err != nil {
+		return "", nil, fmt.Errorf("line %d: %w", r.line+1, err)
+	}
+	return "", nil, io.EOF
+}
+
+// Line is the line number of the record last returned by Next
+func (r *Reader) Line() int {
+	return r.line
+}
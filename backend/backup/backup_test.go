@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type testRecord struct {
+	Type string
+	Data map[string]any
+}
+
+// readAll reads the records of a backup until io.EOF or an error
+func readAll(r io.Reader) (*Reader, []testRecord, error) {
+	br, err := NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var records []testRecord
+	for {
+		recordType, data, err := br.Next()
+		if err == io.EOF {
+			return br, records, nil
+		}
+		if err != nil {
+			return br, records, err
+		}
+		rec := testRecord{Type: recordType}
+		if err := json.Unmarshal(data, &rec.Data); err != nil {
+			return br, records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+func writeTestBackup(t *testing.T, w io.Writer, records []testRecord) {
+	t.Helper()
+	bw, err := NewWriter(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if err := bw.Write(rec.Type, rec.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var testRecords = []testRecord{
+	{TypeCategory, map[string]any{"name": "News"}},
+	{TypeFeed, map[string]any{"url": "https://example.com/rss", "category": "News"}},
+	{TypeArticle, map[string]any{"feed": "https://example.com/rss", "description": "<p>a & b</p>"}},
+	{TypeArticle, map[string]any{"feed": "https://example.com/rss", "description": "<p>second</p>"}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		gzip bool
+	}{
+		{"plain", false},
+		{"gzip", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if tt.gzip {
+				gz := gzip.NewWriter(&buf)
+				writeTestBackup(t, gz, testRecords)
+				if err := gz.Close(); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				writeTestBackup(t, &buf, testRecords)
+			}
+
+			br, records, err := readAll(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if br.Header.Format != Format || br.Header.Version != Version {
+				t.Errorf("header = %+v", br.Header)
+			}
+			if len(records) != len(testRecords) {
+				t.Fatalf("read %d records, want %d", len(records), len(testRecords))
+			}
+			for i, rec := range records {
+				want := testRecords[i]
+				if rec.Type != want.Type || rec.Data["description"] != want.Data["description"] || rec.Data["url"] != want.Data["url"] {
+					t.Errorf("record %d = %+v, want %+v", i, rec, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterDoesNotEscapeHTML(t *testing.T) {
+	var buf bytes.Buffer
+	writeTestBackup(t, &buf, testRecords)
+	if !strings.Contains(buf.String(), "<p>a & b</p>") {
+		t.Errorf("backup escapes HTML:\n%s", buf.String())
+	}
+}
+
+func TestHeaderCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		backup  string
+		wantErr string
+	}{
+		{"empty", "", "backup is empty"},
+		{"blank lines only", "\n\n", "backup is empty"},
+		{"not json", "hello\n", "line 1"},
+		{"no header", `{"type":"feed","data":{}}`, "missing header"},
+		{"other format", `{"type":"header","data":{"format":"other","version":1}}`, `format is "other"`},
+		{"version 0", `{"type":"header","data":{"format":"go-reader-backup","version":0}}`, "unsupported backup version 0"},
+		{"newer version", `{"type":"header","data":{"format":"go-reader-backup","version":99}}`, "unsupported backup version 99"},
+		{"version 1", `{"type":"header","data":{"format":"go-reader-backup","version":1}}`, ""},
+		{"current version", `{"type":"header","data":{"format":"go-reader-backup","version":2}}` + "\n" + `{"type":"end","data":{}}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readAll(strings.NewReader(tt.backup))
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestEndRecord(t *testing.T) {
+	header := `{"type":"header","data":{"format":"go-reader-backup","version":2}}` + "\n"
+	feed := `{"type":"feed","data":{"url":"https://example.com/rss"}}` + "\n"
+	tests := []struct {
+		name    string
+		backup  string
+		wantErr string
+	}{
+		{"complete", header + feed + `{"type":"end","data":{"feed":1}}`, ""},
+		{"missing", header + feed, "no end record"},
+		{"fewer records", header + `{"type":"end","data":{"feed":1}}`, "0 feed records, expected 1"},
+		{"uncounted records", header + feed + `{"type":"end","data":{}}`, "doesn't count"},
+		{"records after end", header + `{"type":"end","data":{}}` + "\n" + feed, "after the end record"},
+		{"second header", header + header + `{"type":"end","data":{}}`, "unexpected header"},
+		{"invalid end", header + `{"type":"end","data":[1]}`, "invalid end record"},
+		{"version 1 needs none", strings.Replace(header, `"version":2`, `"version":1`, 1) + feed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readAll(strings.NewReader(tt.backup))
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestTruncatedBackup(t *testing.T) {
+	var buf bytes.Buffer
+	writeTestBackup(t, &buf, testRecords)
+	lines := strings.SplitAfter(buf.String(), "\n") // The last element is empty
+
+	// Every cut at a line boundary before the end record must be detected
+	for n := 1; n <= len(lines)-2; n++ {
+		_, _, err := readAll(strings.NewReader(strings.Join(lines[:n], "")))
+		if err == nil {
+			t.Errorf("backup cut after %d lines was accepted", n)
+		}
+	}
+}
+
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil {
+		t.Fatalf("no error, want one containing %q", want)
+	}
+	if errors.Is(err, io.EOF) || !strings.Contains(err.Error(), want) {
+		t.Fatalf("error = %v, want one containing %q", err, want)
+	}
+}
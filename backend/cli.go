@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/config"
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/rss"
+)
+
+const usage = `Usage: go-reader [flags] [command]
+
+Without a command the server is started. Run "go-reader -h" to list the
+configuration flags.
+
+Commands:
+  serve                          Run the HTTP server and the background fetcher
+  feeds add [-category name] <url>
+                                 Subscribe to a feed and import its articles
+  feeds list                     List subscribed feeds
+  feeds remove <id|url>...       Unsubscribe from feeds, deleting their articles
+  feeds refresh [id|url]...      Fetch feeds now (all feeds without arguments)
+  opml import <file>             Subscribe to the feeds in an OPML file ("-" reads stdin)
+  opml export [-o file]          Write all subscriptions as OPML
+  migrate                        Create or update the database schema and exit
+  prune [-days n] [-keep n] [-unread] [-dry-run]
+                                 Delete old read articles
+  backup [-o file] [-secrets]    Write a backup of all data (gzip compressed when file ends in .gz),
+                                 with webhook secrets only if -secrets is given
+  restore <file>                 Merge a backup into the database ("-" reads stdin)
+  config print                   Show the effective configuration with secrets redacted
+`
+
+// runCommand runs a subcommand against the configured database. Commands
+// use the db and rss packages directly, so they work while the server is
+// running (or without it) and don't need the HTTP API.
+func runCommand(cfg *config.Config, args []string) error {
+	// Interrupting a command (or the server) cancels its queries and fetches
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "serve":
+		return serve(ctx, cfg)
+	case "feeds":
+		return runFeeds(ctx, cfg, args[1:])
+	case "opml":
+		return runOPML(ctx, cfg, args[1:])
+	case "migrate":
+		return runMigrate(ctx, cfg)
+	case "prune":
+		return runPrune(ctx, cfg, args[1:])
+	case "backup":
+		return runBackup(ctx, cfg, args[1:])
+	case "restore":
+		return runRestore(ctx, cfg, args[1:])
+	case "config":
+		return runConfig(ctx, cfg, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// subcommand returns the first argument of a command group like "feeds"
+func subcommand(group string, args []string) (string, []string, error) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return "", nil, fmt.Errorf("%s needs a subcommand", group)
+	}
+	return args[0], args[1:], nil
+}
+
+func runFeeds(ctx context.Context, cfg *config.Config, args []string) error {
+	cmd, args, err := subcommand("feeds", args)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "add":
+		flags := flag.NewFlagSet("feeds add", flag.ExitOnError)
+		category := flags.String("category", "", "category to put the feed in, created if missing")
+		flags.Parse(args)
+		if flags.NArg() != 1 {
+			return errors.New("usage: go-reader feeds add [-category name] <url>")
+		}
+
+		if err := setup(ctx, cfg); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		feed, err := rss.CreateFeed(ctx, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		if *category != "" {
+			if err := rss.SetFeedCategory(ctx, feed.ID, *category); err != nil {
+				return err
+			}
+		}
+		rss.FindLeadImagesQueued(ctx)
+		rss.ArchiveQueued(ctx)
+		fmt.Printf("Added feed %d: %s\n", feed.ID, feed.Title)
+		return nil
+
+	case "list":
+		if err := setup(ctx, cfg); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		feeds, err := db.GetAllRSS(ctx)
+		if err != nil {
+			return err
+		}
+		categories, err := db.GetAllCategories(ctx)
+		if err != nil {
+			return err
+		}
+		names := map[int]string{}
+		for _, c := range categories {
+			names[c.ID] = c.Name
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCATEGORY\tTITLE\tURL")
+		for _, feed := range feeds {
+			category := "-"
+			if feed.CategoryID != nil {
+				category = names[*feed.CategoryID]
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", feed.ID, category, feed.Title, feed.URL)
+		}
+		return w.Flush()
+
+	case "remove":
+		if len(args) == 0 {
+			return errors.New("usage: go-reader feeds remove <id|url>...")
+		}
+		if err := setup(ctx, cfg); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		for _, arg := range args {
+			feed, err := findFeed(ctx, arg)
+			if err != nil {
+				return err
+			}
+			if err := db.DeleteRSSByID(ctx, feed.ID); err != nil {
+				return err
+			}
+			fmt.Printf("Removed feed %d: %s\n", feed.ID, feed.URL)
+		}
+		if err := rss.SweepArchives(ctx); err != nil {
+			return err
+		}
+		return nil
+
+	case "refresh":
+		if err := setup(ctx, cfg); err != nil {
+			return err
+		}
+		defer db.Close()
+		defer rss.ArchiveQueued(ctx)
+		defer rss.FindLeadImagesQueued(ctx)
+
+		if len(args) == 0 {
+			rss.FetchNewArticles(ctx)
+			return nil
+		}
+		for _, arg := range args {
+			feed, err := findFeed(ctx, arg)
+			if err != nil {
+				return err
+			}
+			if err := rss.SaveRSSArticles(ctx, rss.GetRSSFiveURL(feed.URL), feed.ID); err != nil {
+				return fmt.Errorf("refreshing feed %d: %w", feed.ID, err)
+			}
+			fmt.Printf("Refreshed feed %d: %s\n", feed.ID, feed.Title)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown feeds subcommand %q", cmd)
+	}
+}
+
+// findFeed looks a feed up by ID or URL
+func findFeed(ctx context.Context, arg string) (*db.RSS, error) {
+	if id, err := strconv.Atoi(arg); err == nil {
+		feed, err := db.GetRSSByID(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("feed %d not found", id)
+		}
+		return feed, nil
+	}
+
+	feed, err := db.GetRSSByURL(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+	if feed == nil {
+		return nil, fmt.Errorf("feed %s not found", arg)
+	}
+	return feed, nil
+}
+
+func runOPML(ctx context.Context, cfg *config.Config, args []string) error {
+	cmd, args, err := subcommand("opml", args)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "import":
+		if len(args) != 1 {
+			return errors.New("usage: go-reader opml import <file>")
+		}
+		in, closeIn, err := openInput(args[0])
+		if err != nil {
+			return err
+		}
+		defer closeIn()
+
+		if err := setup(ctx, cfg); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		result, err := rss.ImportOPML(ctx, in)
+		if err != nil {
+			return err
+		}
+		rss.FindLeadImagesQueued(ctx)
+		rss.ArchiveQueued(ctx)
+
+		fmt.Printf("Added %d feeds, %d already subscribed, %d failed\n",
+			len(result.Added), len(result.Existing), len(result.Failed))
+		for _, failure := range result.Failed {
+			fmt.Printf("  %s: %s\n", failure.URL, failure.Error)
+		}
+		return nil
+
+	case "export":
+		flags := flag.NewFlagSet("opml export", flag.ExitOnError)
+		output := flags.String("o", "-", "file to write to, - for stdout")
+		flags.Parse(args)
+
+		if err := setup(ctx, cfg); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		return writeOutput(*output, func(w io.Writer) error {
+			return rss.WriteOPML(ctx, w)
+		})
+
+	default:
+		return fmt.Errorf("unknown opml subcommand %q", cmd)
+	}
+}
+
+func runMigrate(ctx context.Context, cfg *config.Config) error {
+	if err := initDatabase(ctx, cfg); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Println("Database schema is up to date")
+	return nil
+}
+
+func runPrune(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	days := flags.Int("days", 90, "delete articles stored more than this many days ago")
+	keep := flags.Int("keep", 20, "always keep this many of the newest articles of each feed")
+	unread := flags.Bool("unread", false, "delete unread articles too")
+	dryRun := flags.Bool("dry-run", false, "only count the articles that would be deleted")
+	flags.Parse(args)
+	if *days < 0 || *keep < 0 {
+		return errors.New("-days and -keep must not be negative")
+	}
+
+	if err := setup(ctx, cfg); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	before := time.Now().AddDate(0, 0, -*days)
+	count, err := db.PruneArticles(ctx, before, *keep, *unread, *dryRun)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("Would delete %d articles\n", count)
+		return nil
+	}
+
+	// Archived snapshots of the deleted articles
+	if err := rss.SweepArchives(ctx); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %d articles\n", count)
+	return nil
+}
+
+func runBackup(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	output := flags.String("o", "-", "file to write the backup to, - for stdout")
+	secrets := flags.Bool("secrets", false, "include webhook signing secrets")
+	flags.Parse(args)
+
+	if err := setup(ctx, cfg); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return writeOutput(*output, func(w io.Writer) error {
+		if !strings.HasSuffix(*output, ".gz") {
+			return rss.WriteBackup(ctx, w, *secrets)
+		}
+		gz := gzip.NewWriter(w)
+		if err := rss.WriteBackup(ctx, gz, *secrets); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+func runRestore(ctx context.Context, cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: go-reader restore <file>")
+	}
+	in, closeIn, err := openInput(args[0])
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+
+	if err := setup(ctx, cfg); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats, err := rss.RestoreBackup(ctx, in)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored backup (version %d): added %d categories, %d feeds, %d tags, %d webhooks, %d rules, %d articles, %d annotations; merged %d existing articles\n",
+		stats.Version, stats.Categories, stats.Feeds, stats.Tags, stats.Webhooks, stats.Rules,
+		stats.Articles, stats.Annotations, stats.ArticlesMerged)
+	return nil
+}
+
+func runConfig(ctx context.Context, cfg *config.Config, args []string) error {
+	cmd, _, err := subcommand("config", args)
+	if err != nil {
+		return err
+	}
+	if cmd != "print" {
+		return fmt.Errorf("unknown config subcommand %q", cmd)
+	}
+	return cfg.Redacted().WriteYAML(os.Stdout)
+}
+
+// openInput opens a file to read, or stdin for "-"
+func openInput(name string) (io.Reader, func(), error) {
+	if name == "-" {
+		return os.Stdin, func() {}, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// writeOutput calls write with a buffered file, or stdout for "-"
+func writeOutput(name string, write func(io.Writer) error) error {
+	if name == "-" {
+		buffered := bufio.NewWriter(os.Stdout)
+		if err := write(buffered); err != nil {
+			return err
+		}
+		return buffered.Flush()
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(f)
+	if err := write(buffered); err != nil {
+		f.Close()
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
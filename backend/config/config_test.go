@@ -0,0 +1,216 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv blanks every setting's environment variable for the test, so
+// the host environment doesn't leak in (Load ignores empty values), then
+// sets PUBLIC_URL, which the image proxy requires
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for _, s := range Default().settings() {
+		t.Setenv(s.env, "")
+	}
+	t.Setenv("PUBLIC_URL", "https://reader.example.com")
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLayers(t *testing.T) {
+	file := "server:\n  port: \"9000\"\nfetcher:\n  interval: 10m\nlogging:\n  level: debug\n"
+
+	tests := []struct {
+		name     string
+		file     string // Passed with -config, or CONFIG_FILE if fileEnv
+		fileEnv  bool
+		env      map[string]string
+		args     []string
+		check    func(*Config) bool
+		wantArgs []string
+	}{
+		{
+			name: "defaults",
+			check: func(c *Config) bool {
+				return c.Server.Port == "8080" && c.Fetcher.Interval == 5*time.Minute && c.Logging.Level == "info"
+			},
+		},
+		{
+			name: "file overrides defaults",
+			file: file,
+			check: func(c *Config) bool {
+				return c.Server.Port == "9000" && c.Fetcher.Interval == 10*time.Minute && c.Logging.Format == "json"
+			},
+		},
+		{
+			name: "env overrides file",
+			file: file,
+			env:  map[string]string{"PORT": "9100", "LOG_LEVEL": "warn"},
+			check: func(c *Config) bool {
+				return c.Server.Port == "9100" && c.Logging.Level == "warn" && c.Fetcher.Interval == 10*time.Minute
+			},
+		},
+		{
+			name:  "flags override env",
+			file:  file,
+			env:   map[string]string{"PORT": "9100", "LOG_LEVEL": "warn"},
+			args:  []string{"-server.port", "9200"},
+			check: func(c *Config) bool { return c.Server.Port == "9200" && c.Logging.Level == "warn" },
+		},
+		{
+			name:    "file from CONFIG_FILE",
+			file:    file,
+			fileEnv: true,
+			check:   func(c *Config) bool { return c.Server.Port == "9000" },
+		},
+		{
+			name: "parsed types",
+			env:  map[string]string{"CORS_ORIGINS": "https://a.example, ,https://b.example", "DB_MAX_CONNS": "20", "KEEP_REVISIONS": "false"},
+			args: []string{"-fetcher.interval=2m"},
+			check: func(c *Config) bool {
+				return slices.Equal(c.Server.CORSOrigins, []string{"https://a.example", "https://b.example"}) &&
+					c.Database.MaxConns == 20 && !c.Fetcher.KeepRevisions && c.Fetcher.Interval == 2*time.Minute
+			},
+		},
+		{
+			name:  "no public URL without the proxy",
+			env:   map[string]string{"PUBLIC_URL": "", "IMAGE_PROXY": "false"},
+			check: func(c *Config) bool { return c.Server.PublicURL == "" && !c.Images.Proxy },
+		},
+		{
+			name:     "command after flags",
+			args:     []string{"-server.port", "9200", "feeds", "list"},
+			check:    func(c *Config) bool { return c.Server.Port == "9200" },
+			wantArgs: []string{"feeds", "list"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			args := tt.args
+			if tt.file != "" {
+				path := writeFile(t, tt.file)
+				if tt.fileEnv {
+					t.Setenv("CONFIG_FILE", path)
+				} else {
+					args = append([]string{"-config", path}, args...)
+				}
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, rest, err := Load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("unexpected configuration: %+v", cfg)
+			}
+			if !slices.Equal(rest, tt.wantArgs) {
+				t.Errorf("args = %q, want %q", rest, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFlagOverridesEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "server:\n  port: \"9000\"\n"))
+
+	cfg, _, err := Load([]string{"-config", writeFile(t, "server:\n  port: \"9300\"\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != "9300" {
+		t.Errorf("port = %q, want the -config file's 9300", cfg.Server.Port)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr []string
+	}{
+		{
+			name:    "unknown file key",
+			file:    "server:\n  prot: \"9000\"\n",
+			wantErr: []string{"field prot not found"},
+		},
+		{
+			name:    "missing file",
+			args:    []string{"-config", filepath.Join(os.TempDir(), "go-reader-missing.yaml")},
+			wantErr: []string{"reading config file"},
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"-server.prot", "9000"},
+			wantErr: []string{"flag provided but not defined"},
+		},
+		{
+			name:    "proxy without public URL",
+			env:     map[string]string{"PUBLIC_URL": ""},
+			wantErr: []string{"server.publicUrl: is required while images.proxy is on"},
+		},
+		{
+			name: "parse errors reported together",
+			env:  map[string]string{"DB_MAX_CONNS": "abc", "KEEP_REVISIONS": "maybe"},
+			args: []string{"-fetcher.interval", "5"},
+			wantErr: []string{
+				`DB_MAX_CONNS: "abc" is not a whole number`,
+				`KEEP_REVISIONS: "maybe" is not true or false`,
+				`-fetcher.interval: "5" is not a duration`,
+			},
+		},
+		{
+			name: "invalid values reported together",
+			file: "server:\n  port: \"70000\"\n",
+			env:  map[string]string{"LOG_FORMAT": "xml", "CORS_ORIGINS": "https://a.example/path"},
+			wantErr: []string{
+				`server.port: "70000" is not a port number`,
+				`logging.format: "xml" is not json or text`,
+				`server.corsOrigins: "https://a.example/path" is not an origin`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, _, err := Load(args)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
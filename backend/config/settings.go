@@ -0,0 +1,240 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a configuration value that can be set by an environment
+// variable and by a flag named after its key in the config file
+type setting struct {
+	key    string // Path in the config file, also the flag name
+	env    string
+	usage  string
+	secret bool
+	value  any // Pointer into the Config
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "server.port", env: "PORT", usage: "HTTP port", value: &c.Server.Port},
+		{key: "server.publicUrl", env: "PUBLIC_URL", usage: "base URL clients reach the backend at", value: &c.Server.PublicURL},
+		{key: "server.corsOrigins", env: "CORS_ORIGINS", usage: "comma separated origins allowed to call the API, * for any", value: &c.Server.CORSOrigins},
+		{key: "server.feedToken", env: "FEED_TOKEN", usage: "token required to read republished feeds", secret: true, value: &c.Server.FeedToken},
+
+		{key: "database.url", env: "DATABASE_URL", usage: "PostgreSQL connection string", secret: true, value: &c.Database.URL},
+		{key: "database.maxConns", env: "DB_MAX_CONNS", usage: "maximum connections in the pool", value: &c.Database.MaxConns},
+		{key: "database.minConns", env: "DB_MIN_CONNS", usage: "connections the pool keeps open", value: &c.Database.MinConns},
+		{key: "database.maxConnLifetime", env: "DB_MAX_CONN_LIFETIME", usage: "time after which a connection is replaced", value: &c.Database.MaxConnLifetime},
+		{key: "database.maxConnIdleTime", env: "DB_MAX_CONN_IDLE_TIME", usage: "time after which an idle connection is closed", value: &c.Database.MaxConnIdleTime},
+		{key: "database.healthCheckPeriod", env: "DB_HEALTH_CHECK_PERIOD", usage: "time between connection health checks", value: &c.Database.HealthCheckPeriod},
+
+		{key: "fetcher.interval", env: "FETCH_INTERVAL", usage: "time between fetches of all feeds", value: &c.Fetcher.Interval},
+		{key: "fetcher.fiveFiltersUrl", env: "FIVEFILTERS_URL", usage: "FiveFilters full-text RSS service", value: &c.Fetcher.FiveFiltersURL},
+		{key: "fetcher.fiveFiltersMaxItems", env: "FIVEFILTERS_MAX_ITEMS", usage: "items FiveFilters extracts per fetch", value: &c.Fetcher.FiveFiltersMaxItems},
+		{key: "fetcher.keepRevisions", env: "KEEP_REVISIONS", usage: "keep the previous version of edited articles", value: &c.Fetcher.KeepRevisions},
+		{key: "fetcher.historyDays", env: "FETCH_HISTORY_DAYS", usage: "days of fetch attempts kept per feed", value: &c.Fetcher.HistoryDays},
+
+		{key: "sanitizer.extraElements", env: "SANITIZER_EXTRA_ELEMENTS", usage: "comma separated HTML elements to allow in article content", value: &c.Sanitizer.ExtraElements},
+		{key: "sanitizer.extraAttributes", env: "SANITIZER_EXTRA_ATTRIBUTES", usage: "comma separated HTML attributes to allow on every element", value: &c.Sanitizer.ExtraAttributes},
+
+		{key: "images.proxy", env: "IMAGE_PROXY", usage: "load article images through the image proxy", value: &c.Images.Proxy},
+		{key: "images.proxyKey", env: "IMAGE_PROXY_KEY", usage: "key signing image proxy URLs", secret: true, value: &c.Images.ProxyKey},
+		{key: "images.cacheMB", env: "IMAGE_CACHE_MB", usage: "size of the image cache in MB, 0 disables it", value: &c.Images.CacheMB},
+
+		{key: "storage.cacheDir", env: "CACHE_DIR", usage: "directory for cached files", value: &c.Storage.CacheDir},
+		{key: "storage.archiveDir", env: "ARCHIVE_DIR", usage: "directory for archived article snapshots", value: &c.Storage.ArchiveDir},
+
+		{key: "logging.level", env: "LOG_LEVEL", usage: "least severe log level written: debug, info, warn or error", value: &c.Logging.Level},
+		{key: "logging.format", env: "LOG_FORMAT", usage: "log format: json or text", value: &c.Logging.Format},
+	}
+}
+
+// set parses a value given as an environment variable or flag
+func (s setting) set(value string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		*v = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s, 5m or 1h", value)
+		}
+		*v = d
+	case *[]string:
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*v = list
+	default:
+		panic("config: unsupported setting type for " + s.key)
+	}
+	return nil
+}
+
+// Sanitizer extensions that would let article content run scripts, restyle
+// the page or embed other documents
+var (
+	unsafeElements = map[string]bool{
+		"script": true, "style": true, "iframe": true, "frame": true, "frameset": true,
+		"object": true, "embed": true, "applet": true, "form": true, "input": true,
+		"button": true, "textarea": true, "select": true, "link": true, "meta": true,
+		"base": true, "svg": true, "math": true, "template": true,
+	}
+	unsafeAttributes = map[string]bool{"style": true, "srcdoc": true, "formaction": true}
+	htmlName         = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+)
+
+// validate returns every problem with the configuration
+func (c *Config) validate() []error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		fail("server.port", "%q is not a port number", c.Server.Port)
+	}
+	if c.Server.PublicURL == "" {
+		if c.Images.Proxy {
+			fail("server.publicUrl", "is required while images.proxy is on, proxied image URLs are built from it")
+		}
+	} else if !isHTTPURL(c.Server.PublicURL) {
+		fail("server.publicUrl", "%q is not an http(s) URL", c.Server.PublicURL)
+	}
+	if len(c.Server.CORSOrigins) == 0 {
+		fail("server.corsOrigins", "at least one origin is required, * allows any")
+	}
+	for _, origin := range c.Server.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || !isHTTPURL(origin) || strings.TrimSuffix(u.Path, "/") != "" {
+			fail("server.corsOrigins", "%q is not an origin like https://reader.example.com", origin)
+		}
+	}
+
+	if c.Database.URL == "" {
+		fail("database.url", "is required")
+	}
+	if c.Database.MaxConns < 1 {
+		fail("database.maxConns", "must be at least 1, got %d", c.Database.MaxConns)
+	}
+	if c.Database.MinConns < 0 || c.Database.MinConns > c.Database.MaxConns {
+		fail("database.minConns", "must be between 0 and maxConns (%d), got %d", c.Database.MaxConns, c.Database.MinConns)
+	}
+	if c.Database.MaxConnLifetime <= 0 {
+		fail("database.maxConnLifetime", "must be positive, got %s", c.Database.MaxConnLifetime)
+	}
+	if c.Database.MaxConnIdleTime <= 0 {
+		fail("database.maxConnIdleTime", "must be positive, got %s", c.Database.MaxConnIdleTime)
+	}
+	if c.Database.HealthCheckPeriod <= 0 {
+		fail("database.healthCheckPeriod", "must be positive, got %s", c.Database.HealthCheckPeriod)
+	}
+
+	if c.Fetcher.Interval < time.Minute {
+		fail("fetcher.interval", "must be at least 1m, got %s", c.Fetcher.Interval)
+	}
+	if !isHTTPURL(c.Fetcher.FiveFiltersURL) {
+		fail("fetcher.fiveFiltersUrl", "%q is not an http(s) URL", c.Fetcher.FiveFiltersURL)
+	}
+	if c.Fetcher.FiveFiltersMaxItems < 1 {
+		fail("fetcher.fiveFiltersMaxItems", "must be at least 1, got %d", c.Fetcher.FiveFiltersMaxItems)
+	}
+	if c.Fetcher.HistoryDays < 1 {
+		fail("fetcher.historyDays", "must be at least 1, got %d", c.Fetcher.HistoryDays)
+	}
+
+	for _, element := range c.Sanitizer.ExtraElements {
+		if !htmlName.MatchString(element) {
+			fail("sanitizer.extraElements", "%q is not a lowercase element name", element)
+		} else if unsafeElements[element] {
+			fail("sanitizer.extraElements", "%q can't be allowed in article content", element)
+		}
+	}
+	for _, attr := range c.Sanitizer.ExtraAttributes {
+		if !htmlName.MatchString(attr) {
+			fail("sanitizer.extraAttributes", "%q is not a lowercase attribute name", attr)
+		} else if unsafeAttributes[attr] || strings.HasPrefix(attr, "on") {
+			fail("sanitizer.extraAttributes", "%q can't be allowed in article content", attr)
+		}
+	}
+
+	if c.Images.CacheMB < 0 {
+		fail("images.cacheMB", "must not be negative, got %d", c.Images.CacheMB)
+	}
+	if c.Storage.CacheDir == "" {
+		fail("storage.cacheDir", "is required")
+	}
+	if c.Storage.ArchiveDir == "" {
+		fail("storage.archiveDir", "is required")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		fail("logging.level", "%q is not debug, info, warn or error", c.Logging.Level)
+	}
+	if c.Logging.Format != "json" && c.Logging.Format != "text" {
+		fail("logging.format", "%q is not json or text", c.Logging.Format)
+	}
+	return errs
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// passwordParam matches the password in a key=value connection string
+var passwordParam = regexp.MustCompile(`(?i)(password\s*=\s*)('[^']*'|\S+)`)
+
+// Redacted returns a copy of the configuration with secrets replaced, safe
+// to print or log
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, s := range redacted.settings() {
+		v, ok := s.value.(*string)
+		if !s.secret || !ok || *v == "" {
+			continue
+		}
+		if s.key == "database.url" {
+			*v = redactDatabaseURL(*v)
+			continue
+		}
+		*v = "REDACTED"
+	}
+	return &redacted
+}
+
+// redactDatabaseURL hides the password in a URL or key=value connection
+// string and keeps the rest, which is useful for checking the host
+func redactDatabaseURL(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		return u.String()
+	}
+	return passwordParam.ReplaceAllString(dsn, "${1}REDACTED")
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Annotation kinds
+const (
+	AnnotationHighlight = "highlight"
+	AnnotationNote      = "note"
+)
+
+// Annotation is a highlight or a free-form note on an article. Highlights
+// are anchored by Exact/Prefix/Suffix (see the annotations package); Start
+// and End are rune offsets into the article's plain text when it was saved.
+// A note may also be attached to a highlight through Note.
+type Annotation struct {
+	ID        int
+	ArticleID int
+	Kind      string
+	Exact     string
+	Prefix    string
+	Suffix    string
+	Start     int
+	End       int
+	Note      string
+	Color     string
+	Orphaned  bool // Set when the highlighted text is no longer in the article; not stored
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func CreateAnnotationTable(ctx context.Context) error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS annotation (
+	id SERIAL PRIMARY KEY,
+	articleID INT NOT NULL REFERENCES article(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	kind TEXT NOT NULL,
+	exact TEXT NOT NULL DEFAULT '',
+	prefix TEXT NOT NULL DEFAULT '',
+	suffix TEXT NOT NULL DEFAULT '',
+	start_offset INT NOT NULL DEFAULT 0,
+	end_offset INT NOT NULL DEFAULT 0,
+	note TEXT NOT NULL DEFAULT '',
+	color TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE INDEX IF NOT EXISTS idx_annotation_article ON annotation (articleID)`,
+	}
+
+	for _, query := range queries {
+		if _, err := DB.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const annotationColumns = `id, articleID, kind, exact, prefix, suffix, start_offset, end_offset, note, color, created_at, updated_at`
+
+func scanAnnotation(row pgx.Row) (Annotation, error) {
+	var a Annotation
+	err := row.Scan(&a.ID, &a.ArticleID, &a.Kind, &a.Exact, &a.Prefix, &a.Suffix, &a.Start, &a.End,
+		&a.Note, &a.Color, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+func queryAnnotations(ctx context.Context, query string, args ...any) ([]Annotation, error) {
+	rows, err := DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var annotations []Annotation
+	for rows.Next() {
+		a, err := scanAnnotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, rows.Err()
+}
+
+func CreateAnnotation(ctx context.Context, a *Annotation) (*Annotation, error) {
+	query := `
+	INSERT INTO annotation (articleID, kind, exact, prefix, suffix, start_offset, end_offset, note, color)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING ` + annotationColumns
+
+	created, err := scanAnnotation(DB.QueryRow(ctx, query, a.ArticleID, a.Kind,
+		a.Exact, a.Prefix, a.Suffix, a.Start, a.End, a.Note, a.Color))
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetAnnotationsByArticle returns an article's annotations in reading order
+// (notes without a highlight come first)
+func GetAnnotationsByArticle(ctx context.Context, articleID int) ([]Annotation, error) {
+	return queryAnnotations(ctx, `SELECT `+annotationColumns+` FROM annotation WHERE articleID = $1
+	ORDER BY start_offset, id`, articleID)
+}
+
+// GetAllAnnotations returns every annotation grouped by article
+func GetAllAnnotations(ctx context.Context) ([]Annotation, error) {
+	return queryAnnotations(ctx, `SELECT `+annotationColumns+` FROM annotation ORDER BY articleID, start_offset, id`)
+}
+
+func GetAnnotationByID(ctx context.Context, id int) (*Annotation, error) {
+	a, err := scanAnnotation(DB.QueryRow(ctx,
+		`SELECT `+annotationColumns+` FROM annotation WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// UpdateAnnotation saves all editable fields of an annotation
+func UpdateAnnotation(ctx context.Context, a *Annotation) error {
+	query := `
+	UPDATE annotation
+	SET exact = $1, prefix = $2, suffix = $3, start_offset = $4, end_offset = $5, note = $6, color = $7,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = $8`
+
+	result, err := DB.Exec(ctx, query, a.Exact, a.Prefix, a.Suffix, a.Start, a.End,
+		a.Note, a.Color, a.ID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("annotation with ID %d not found", a.ID)
+	}
+	return nil
+}
+
+func DeleteAnnotation(ctx context.Context, id int) error {
+	result, err := DB.Exec(ctx, `DELETE FROM annotation WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("annotation with ID %d not found", id)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Archive is an offline snapshot of the page an article links to. The
+// snapshot itself is kept in the archive blob store under Key.
+type Archive struct {
+	ArticleID int
+	URL       string // The page that was archived
+	Size      int64  // Bytes
+	Key       string `json:"-"`
+	CreatedAt time.Time
+}
+
+func CreateArchiveTable(ctx context.Context) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS article_archive (
+	articleID INT PRIMARY KEY REFERENCES article(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	blob_key TEXT NOT NULL,
+	url TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := DB.Exec(ctx, query)
+	return err
+}
+
+// GetArchive returns an article's snapshot record, or nil when the article
+// hasn't been archived
+func GetArchive(ctx context.Context, articleID int) (*Archive, error) {
+	a := &Archive{}
+	err := DB.QueryRow(ctx, `
+	SELECT articleID, url, size, blob_key, created_at FROM article_archive WHERE articleID = $1`, articleID).
+		Scan(&a.ArticleID, &a.URL, &a.Size, &a.Key, &a.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SaveArchive records an article's snapshot, replacing any earlier one
+func SaveArchive(ctx context.Context, a *Archive) error {
+	return DB.QueryRow(ctx, `
+	INSERT INTO article_archive (articleID, blob_key, url, size)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (articleID) DO UPDATE
+	SET blob_key = EXCLUDED.blob_key, url = EXCLUDED.url, size = EXCLUDED.size, created_at = CURRENT_TIMESTAMP
+	RETURNING created_at`, a.ArticleID, a.Key, a.URL, a.Size).Scan(&a.CreatedAt)
+}
+
+// DeleteArchive removes an article's snapshot record and returns its blob key
+func DeleteArchive(ctx context.Context, articleID int) (string, error) {
+	var key string
+	err := DB.QueryRow(ctx,
+		`DELETE FROM article_archive WHERE articleID = $1 RETURNING blob_key`, articleID).Scan(&key)
+	if err == pgx.ErrNoRows {
+		return "", fmt.Errorf("article %d has not been archived", articleID)
+	}
+	return key, err
+}
+
+// ArchiveKeyInUse reports whether any article still has the snapshot with
+// this key
+func ArchiveKeyInUse(ctx context.Context, key string) (bool, error) {
+	var inUse bool
+	err := DB.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM article_archive WHERE blob_key = $1)`, key).Scan(&inUse)
+	return inUse, err
+}
+
+// GetArchiveKeys returns the keys of every snapshot that's still referenced
+func GetArchiveKeys(ctx context.Context) (map[string]bool, error) {
+	rows, err := DB.Query(ctx, `SELECT DISTINCT blob_key FROM article_archive`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := map[string]bool{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
@@ -18,12 +18,29 @@ type Article struct {
 	PublishDate string
 	Format      string
 	Identifier  string
+	Author      string
+	Categories  []string // Item level <category> values from the feed
 	Read        bool
+	Starred     bool
+	Tags        []string
+	Enclosures  []Enclosure
+	LeadImage   string       // URL of the picture shown with the article, empty if none was found
+	ArchivedAt  *time.Time   // When the linked page was last archived, nil if it never was
+	ClusterID   *int         // Shared by near-duplicates of the same story across feeds
+	AlsoIn      []ArticleRef `json:",omitempty"` // Other copies, filled in when listings collapse clusters
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
-func CreateArticleTable() error {
+// ArticleRef points at another copy of a clustered article
+type ArticleRef struct {
+	ID    int
+	RssID int
+	Title string
+	Link  string
+}
+
+func CreateArticleTable(ctx context.Context) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS article (
 	id SERIAL PRIMARY KEY,
@@ -48,32 +65,92 @@ func CreateArticleTable() error {
 	CONSTRAINT unique_article_rss_link UNIQUE (rssID, link)
 	)`
 
-	_, err := DB.Exec(context.Background(), query)
-	return err
+	_, err := DB.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema
+	queries := []string{
+		`ALTER TABLE article ADD COLUMN IF NOT EXISTS starred BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE article ADD COLUMN IF NOT EXISTS url_key TEXT`,
+		`ALTER TABLE article ADD COLUMN IF NOT EXISTS simhash BIGINT`,
+		`ALTER TABLE article ADD COLUMN IF NOT EXISTS clusterID INT`,
+		`ALTER TABLE article ADD COLUMN IF NOT EXISTS content_hash TEXT`,
+		`ALTER TABLE article ADD COLUMN IF NOT EXISTS author TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE article ADD COLUMN IF NOT EXISTS categories TEXT[] NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE article ADD COLUMN IF NOT EXISTS lead_image TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS idx_article_rss_guid ON article (rssID, GUID)`,
+		`CREATE INDEX IF NOT EXISTS idx_article_url_key ON article (url_key)`,
+		`CREATE INDEX IF NOT EXISTS idx_article_cluster ON article (clusterID)`,
+	}
+	for _, query := range queries {
+		if _, err := DB.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func CreateArticle(rssID int, title, link, guid, description string, publishDate string, format, identifier string, read bool) (*Article, error) {
+// articleColumns is the column list matching scanArticle. It must be
+// selected from (or returned by an insert into) the article table.
+const articleColumns = `id, rssID, title, link, GUID, description, publishDate, format, identifier, author, categories, read, starred,
+	COALESCE((SELECT array_agg(t.name ORDER BY t.name) FROM article_tag atg JOIN tag t ON t.id = atg.tagID
+		WHERE atg.articleID = article.id), '{}') AS tags,
+	COALESCE((SELECT json_agg(` + enclosureJSON + ` ORDER BY e.id) FROM enclosure e
+		LEFT JOIN playback_position p ON p.enclosureID = e.id WHERE e.articleID = article.id), '[]') AS enclosures,
+	lead_image, (SELECT aa.created_at FROM article_archive aa WHERE aa.articleID = article.id) AS archived_at,
+	clusterID, created_at, updated_at`
+
+// scanArticle scans a row selected with articleColumns
+func scanArticle(row pgx.Row) (Article, error) {
+	var article Article
+	err := row.Scan(&article.ID, &article.RssID, &article.Title, &article.Link,
+		&article.GUID, &article.Description, &article.PublishDate, &article.Format, &article.Identifier,
+		&article.Author, &article.Categories, &article.Read, &article.Starred, &article.Tags, &article.Enclosures, &article.LeadImage, &article.ArchivedAt, &article.ClusterID, &article.CreatedAt, &article.UpdatedAt)
+	return article, err
+}
+
+// scanArticles collects all rows selected with articleColumns
+func scanArticles(rows pgx.Rows) ([]Article, error) {
+	defer rows.Close()
+
+	var articles []Article
+	for rows.Next() {
+		article, err := scanArticle(rows)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, article)
+	}
+	return articles, rows.Err()
+}
+
+func CreateArticle(ctx context.Context, rssID int, title, link, guid, description string, publishDate string, format, identifier string, author string, categories []string, read bool, contentHash string) (*Article, error) {
+	if categories == nil {
+		categories = []string{}
+	}
+
 	query := `
-	INSERT INTO article (rssID, title, link, GUID, description, publishDate, format, identifier, read)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	INSERT INTO article (rssID, title, link, GUID, description, publishDate, format, identifier, author, categories, read, content_hash)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 	ON CONFLICT (rssID, link) DO NOTHING
-	RETURNING id, rssID, title, link, GUID, description, publishDate, format, identifier, read, created_at, updated_at
-	`
-	article := &Article{}
-	err := DB.QueryRow(context.Background(), query, rssID, title, link, guid, description, publishDate, format, identifier, read).Scan(
-		&article.ID, &article.RssID, &article.Title, &article.Link, &article.GUID, &article.Description, &article.PublishDate, &article.Format, &article.Identifier, &article.Read, &article.CreatedAt, &article.UpdatedAt,
-	)
+	RETURNING ` + articleColumns
 
+	article, err := scanArticle(DB.QueryRow(ctx, query, rssID, title, link, guid, description, publishDate, format, identifier, author, categories, read, contentHash))
 	if err == pgx.ErrNoRows {
 		// Article already existed and wasn't inserted
 		return nil, nil // or return a specific "already exists" indicator
 	}
-	return article, err
+	if err != nil {
+		return nil, err
+	}
+	return &article, nil
 }
 
-func GetArticleByRSSID(id, limit int) ([]Article, error) {
+func GetArticleByRSSID(ctx context.Context, id, limit int) ([]Article, error) {
 	query := `
-	SELECT id, rssID, title, link, GUID, description, publishDate, format, identifier, read, created_at, updated_at
+	SELECT ` + articleColumns + `
 	FROM article
 	WHERE rssid = $1
 	AND publishDate != '' AND publishDate IS NOT NULL
@@ -81,61 +158,38 @@ func GetArticleByRSSID(id, limit int) ([]Article, error) {
 	LIMIT $2
 	`
 
-	rows, err := DB.Query(context.Background(), query, id, limit)
+	rows, err := DB.Query(ctx, query, id, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var articles []Article
-	for rows.Next() {
-		var article Article
-		err := rows.Scan(&article.ID, &article.RssID, &article.Title, &article.Link,
-			&article.GUID, &article.Description, &article.PublishDate, &article.Format, &article.Identifier,
-			&article.Read, &article.CreatedAt, &article.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		articles = append(articles, article)
-	}
-	return articles, rows.Err()
+	return scanArticles(rows)
 }
 
-func GetSingleArticle(id int) ([]Article, error) {
+func GetSingleArticle(ctx context.Context, id int) ([]Article, error) {
 	query := `
-	SELECT id, rssID, title, link, GUID, description, publishDate, format, identifier, read, created_at, updated_at
+	SELECT ` + articleColumns + `
 	FROM article
 	WHERE id = $1
 	`
 
-	rows, err := DB.Query(context.Background(), query, id)
+	rows, err := DB.Query(ctx, query, id)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var articles []Article
-	for rows.Next() {
-		var article Article
-		err := rows.Scan(&article.ID, &article.RssID, &article.Title, &article.Link,
-			&article.GUID, &article.Description, &article.PublishDate, &article.Format, &article.Identifier,
-			&article.Read, &article.CreatedAt, &article.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		articles = append(articles, article)
-	}
-	return articles, rows.Err()
+	return scanArticles(rows)
 }
 
-func UpdateArticleReadStatus(id int, read bool) error {
+// UpdateArticleReadStatus marks an article read or unread, together with
+// all other copies in its near-duplicate cluster
+func UpdateArticleReadStatus(ctx context.Context, id int, read bool) error {
 	query := `
 	UPDATE article
 	SET read = $1
 	WHERE id = $2
+	OR clusterID = (SELECT clusterID FROM article WHERE id = $2)
 	`
 
-	result, err := DB.Exec(context.Background(), query, read, id)
+	result, err := DB.Exec(ctx, query, read, id)
 	if err != nil {
 		return err
 	}
@@ -148,71 +202,135 @@ func UpdateArticleReadStatus(id int, read bool) error {
 	return nil
 }
 
-func GetAllArticles() ([]Article, error) {
+func UpdateArticleStarredStatus(ctx context.Context, id int, starred bool) error {
+	query := `
+	UPDATE article
+	SET starred = $1
+	WHERE id = $2
+	`
+
+	result, err := DB.Exec(ctx, query, starred, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("article with ID %d not found", id)
+	}
+
+	return nil
+}
+
+// SetArticleLeadImage stores the URL of an article's lead image
+func SetArticleLeadImage(ctx context.Context, id int, imageURL string) error {
+	_, err := DB.Exec(ctx, `UPDATE article SET lead_image = $1 WHERE id = $2`, imageURL, id)
+	return err
+}
+
+func GetAllArticles(ctx context.Context) ([]Article, error) {
 	query := `
-	SELECT id, rssID, title, link, GUID, description, publishDate, format, identifier, read, created_at, updated_at
+	SELECT ` + articleColumns + `
 	FROM article
 	WHERE publishDate != '' AND publishDate IS NOT NULL
 	ORDER BY publishDate::TIMESTAMP DESC;
 	`
 
-	rows, err := DB.Query(context.Background(), query)
+	rows, err := DB.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanArticles(rows)
+}
 
-	var articles []Article
-	for rows.Next() {
-		var article Article
-		err := rows.Scan(&article.ID, &article.RssID, &article.Title, &article.Link,
-			&article.GUID, &article.Description, &article.PublishDate, &article.Format, &article.Identifier,
-			&article.Read, &article.CreatedAt, &article.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		articles = append(articles, article)
+// GetRecentArticles returns the newest articles across all feeds
+func GetRecentArticles(ctx context.Context, limit int) ([]Article, error) {
+	query := `
+	SELECT ` + articleColumns + `
+	FROM article
+	WHERE publishDate != '' AND publishDate IS NOT NULL
+	ORDER BY publishDate::TIMESTAMP DESC
+	LIMIT $1
+	`
+
+	rows, err := DB.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
 	}
-	return articles, rows.Err()
+	return scanArticles(rows)
 }
 
-func SearchArticles(query string, limit int) ([]Article, error) {
-	searchQuery := `
-	SELECT id, rssID, title, link, GUID, description, publishDate, format, identifier, read, created_at, updated_at
+// GetArticlesByCategory returns the newest articles from all feeds in a category
+func GetArticlesByCategory(ctx context.Context, categoryID, limit int) ([]Article, error) {
+	query := `
+	SELECT ` + articleColumns + `
 	FROM article
-	WHERE to_tsvector('english', title || ' ' || description) @@ plainto_tsquery('english', $1)
+	WHERE rssID IN (SELECT id FROM rss WHERE categoryID = $1)
 	AND publishDate != '' AND publishDate IS NOT NULL
 	ORDER BY publishDate::TIMESTAMP DESC
 	LIMIT $2
 	`
 
-	rows, err := DB.Query(context.Background(), searchQuery, query, limit)
+	rows, err := DB.Query(ctx, query, categoryID, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanArticles(rows)
+}
 
-	var articles []Article
-	for rows.Next() {
-		var article Article
-		err := rows.Scan(&article.ID, &article.RssID, &article.Title, &article.Link,
-			&article.GUID, &article.Description, &article.PublishDate, &article.Format, &article.Identifier,
-			&article.Read, &article.CreatedAt, &article.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		articles = append(articles, article)
+// GetStarredArticles returns the newest starred articles
+func GetStarredArticles(ctx context.Context, limit int) ([]Article, error) {
+	query := `
+	SELECT ` + articleColumns + `
+	FROM article
+	WHERE starred
+	ORDER BY created_at DESC, id DESC
+	LIMIT $1
+	`
+
+	rows, err := DB.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
 	}
-	return articles, rows.Err()
+	return scanArticles(rows)
+}
+
+// searchDocument is the text articles are searched by
+const searchDocument = `to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, '') || ' ' ||
+	author || ' ' || array_to_string(categories, ' '))`
+
+func SearchArticles(ctx context.Context, query string, limit int) ([]Article, error) {
+	return SearchArticlesFiltered(ctx, query, "", "", limit)
+}
+
+// SearchArticlesFiltered is SearchArticles narrowed to an author and/or an
+// item category (both case insensitive). Empty arguments don't filter.
+func SearchArticlesFiltered(ctx context.Context, query, author, category string, limit int) ([]Article, error) {
+	searchQuery := `
+	SELECT ` + articleColumns + `
+	FROM article
+	WHERE ($1 = '' OR ` + searchDocument + ` @@ plainto_tsquery('english', $1))
+	AND ($2 = '' OR lower(author) = lower($2))
+	AND ($3 = '' OR lower($3) IN (SELECT lower(c) FROM unnest(categories) c))
+	AND publishDate != '' AND publishDate IS NOT NULL
+	ORDER BY publishDate::TIMESTAMP DESC
+	LIMIT $4
+	`
+
+	rows, err := DB.Query(ctx, searchQuery, query, author, category, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanArticles(rows)
 }
 
-func DeleteArticle(id int) error {
+func DeleteArticle(ctx context.Context, id int) error {
 	query := `
 	DELETE FROM article
 	WHERE id = $1
 	`
 
-	result, err := DB.Exec(context.Background(), query, id)
+	result, err := DB.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -224,3 +342,54 @@ func DeleteArticle(id int) error {
 
 	return nil
 }
+
+// GetArticlesByIDs returns the articles with the given IDs in the order the
+// IDs are listed. Unknown IDs are skipped.
+func GetArticlesByIDs(ctx context.Context, ids []int) ([]Article, error) {
+	query := `
+	SELECT ` + articleColumns + `
+	FROM article
+	WHERE id = ANY($1)
+	ORDER BY array_position($1, id)
+	`
+
+	rows, err := DB.Query(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	return scanArticles(rows)
+}
+
+// PruneArticles deletes articles stored before the given time. Starred,
+// tagged and annotated articles are kept, as are unread ones unless
+// includeUnread is set, and the newest keepPerFeed articles of every feed
+// (so items still in a feed aren't stored again as new). With dryRun set
+// nothing is deleted. It returns the number of (prunable) articles.
+func PruneArticles(ctx context.Context, before time.Time, keepPerFeed int, includeUnread, dryRun bool) (int64, error) {
+	candidates := `
+	SELECT id FROM (
+		SELECT id, rssID, created_at, COALESCE(read, false) AS read, starred,
+			ROW_NUMBER() OVER (PARTITION BY rssID ORDER BY created_at DESC, id DESC) AS newest
+		FROM article
+	) a
+	WHERE newest > $2
+	AND created_at < $1
+	AND NOT starred
+	AND ($3 OR read)
+	AND NOT EXISTS (SELECT 1 FROM article_tag atg WHERE atg.articleID = a.id)
+	AND NOT EXISTS (SELECT 1 FROM annotation n WHERE n.articleID = a.id)`
+
+	if dryRun {
+		var count int64
+		err := DB.QueryRow(ctx, `SELECT COUNT(*) FROM (`+candidates+`) c`,
+			before, keepPerFeed, includeUnread).Scan(&count)
+		return count, err
+	}
+
+	result, err := DB.Exec(ctx, `DELETE FROM article WHERE id IN (`+candidates+`)`,
+		before, keepPerFeed, includeUnread)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
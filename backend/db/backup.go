@@ -0,0 +1,540 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Backup records refer to feeds by URL and to categories and tags by name,
+// so a backup can be merged into a database with different IDs. Webhooks
+// and clusters have no natural key; they are referred to by their ID in the
+// backed up database.
+
+type BackupCategory struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+type BackupTag struct {
+	Name string `json:"name"`
+}
+
+type BackupFeed struct {
+	URL         string    `json:"url"`
+	FiveURL     string    `json:"fivefiltersUrl"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	FeedSize    int       `json:"feedSize"`
+	Sync        int       `json:"sync"`
+	Category    string    `json:"category"` // Empty when uncategorized
+	KeepParams  []string  `json:"keepParams"`
+	StripParams []string  `json:"stripParams"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// BackupWebhook is a webhook in a backup. The signing secret is only
+// written on request; SecretOmitted marks a webhook whose secret was left
+// out, so the restore can keep it from sending unsigned deliveries.
+type BackupWebhook struct {
+	ID            int       `json:"id"`
+	URL           string    `json:"url"`
+	Secret        string    `json:"secret,omitempty"`
+	SecretOmitted bool      `json:"secretOmitted,omitempty"`
+	Feed          string    `json:"feed"`
+	Category      string    `json:"category"`
+	Keyword       string    `json:"keyword"`
+	Active        bool      `json:"active"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+type BackupRule struct {
+	Name      string    `json:"name"`
+	Feed      string    `json:"feed"`
+	Category  string    `json:"category"`
+	Field     string    `json:"field"`
+	MatchType string    `json:"matchType"`
+	Pattern   string    `json:"pattern"`
+	Action    string    `json:"action"`
+	WebhookID *int      `json:"webhookId"`
+	Tag       string    `json:"tag"`
+	Enabled   bool      `json:"enabled"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type BackupArticle struct {
+	Feed        string             `json:"feed"`
+	Title       string             `json:"title"`
+	Link        string             `json:"link"`
+	GUID        string             `json:"guid"`
+	Description string             `json:"description"`
+	PublishDate string             `json:"publishDate"`
+	Format      string             `json:"format"`
+	Identifier  string             `json:"identifier"`
+	Author      string             `json:"author"`
+	Categories  []string           `json:"categories"`
+	Read        bool               `json:"read"`
+	Starred     bool               `json:"starred"`
+	LeadImage   string             `json:"leadImage"`
+	ContentHash string             `json:"contentHash"`
+	URLKey      string             `json:"urlKey"`
+	SimHash     int64              `json:"simhash"`
+	ClusterID   *int               `json:"clusterId"`
+	Tags        []string           `json:"tags"`
+	Enclosures  []BackupEnclosure  `json:"enclosures"`
+	Annotations []BackupAnnotation `json:"annotations"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt"`
+}
+
+type BackupEnclosure struct {
+	URL          string `json:"url"`
+	MimeType     string `json:"mimeType"`
+	Length       int64  `json:"length"`
+	Duration     int    `json:"duration"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	Position     int    `json:"position"`
+	Completed    bool   `json:"completed"`
+}
+
+type BackupAnnotation struct {
+	Kind      string    `json:"kind"`
+	Exact     string    `json:"exact"`
+	Prefix    string    `json:"prefix"`
+	Suffix    string    `json:"suffix"`
+	Start     int       `json:"start"`
+	End       int       `json:"end"`
+	Note      string    `json:"note"`
+	Color     string    `json:"color"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Export
+
+func GetBackupFeeds(ctx context.Context) ([]BackupFeed, error) {
+	rows, err := DB.Query(ctx, `
+	SELECT r.url, COALESCE(r.fiveURL, ''), COALESCE(r.title, ''), COALESCE(r.description, ''),
+		COALESCE(r.feedSize, 0), COALESCE(r.sync, 0), COALESCE(c.name, ''),
+		r.url_keep_params, r.url_strip_params, r.created_at
+	FROM rss r
+	LEFT JOIN category c ON c.id = r.categoryID
+	WHERE r.url IS NOT NULL
+	ORDER BY r.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feeds []BackupFeed
+	for rows.Next() {
+		var f BackupFeed
+		err := rows.Scan(&f.URL, &f.FiveURL, &f.Title, &f.Description, &f.FeedSize, &f.Sync, &f.Category,
+			&f.KeepParams, &f.StripParams, &f.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
+
+func GetBackupWebhooks(ctx context.Context) ([]BackupWebhook, error) {
+	rows, err := DB.Query(ctx, `
+	SELECT w.id, w.url, w.secret, COALESCE(r.url, ''), COALESCE(c.name, ''), w.keyword, w.active, w.created_at
+	FROM webhook w
+	LEFT JOIN rss r ON r.id = w.rssID
+	LEFT JOIN category c ON c.id = w.categoryID
+	ORDER BY w.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []BackupWebhook
+	for rows.Next() {
+		var w BackupWebhook
+		err := rows.Scan(&w.ID, &w.URL, &w.Secret, &w.Feed, &w.Category, &w.Keyword, &w.Active, &w.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func GetBackupRules(ctx context.Context) ([]BackupRule, error) {
+	rows, err := DB.Query(ctx, `
+	SELECT u.name, COALESCE(r.url, ''), COALESCE(c.name, ''), u.field, u.match_type, u.pattern, u.action,
+		u.webhookID, u.tag, u.enabled, u.position, u.created_at
+	FROM rule u
+	LEFT JOIN rss r ON r.id = u.rssID
+	LEFT JOIN category c ON c.id = u.categoryID
+	ORDER BY u.position, u.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []BackupRule
+	for rows.Next() {
+		var r BackupRule
+		err := rows.Scan(&r.Name, &r.Feed, &r.Category, &r.Field, &r.MatchType, &r.Pattern, &r.Action,
+			&r.WebhookID, &r.Tag, &r.Enabled, &r.Position, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// EachBackupArticle calls fn with every article in ID order, streaming them
+// from the database rather than loading them all
+func EachBackupArticle(ctx context.Context, fn func(BackupArticle) error) error {
+	rows, err := DB.Query(ctx, `
+	SELECT r.url, COALESCE(a.title, ''), COALESCE(a.link, ''), COALESCE(a.GUID, ''), COALESCE(a.description, ''),
+		COALESCE(a.publishDate, ''), COALESCE(a.format, ''), COALESCE(a.identifier, ''), a.author, a.categories,
+		COALESCE(a.read, false), a.starred, a.lead_image, COALESCE(a.content_hash, ''), COALESCE(a.url_key, ''),
+		COALESCE(a.simhash, 0), a.clusterID,
+		COALESCE((SELECT array_agg(t.name ORDER BY t.name) FROM article_tag atg JOIN tag t ON t.id = atg.tagID
+			WHERE atg.articleID = a.id), '{}'),
+		COALESCE((SELECT json_agg(json_build_object('url', e.url, 'mimeType', e.mime_type, 'length', e.length,
+			'duration', e.duration, 'thumbnailUrl', e.thumbnail_url, 'position', COALESCE(p.position, 0),
+			'completed', COALESCE(p.completed, false)) ORDER BY e.id)
+			FROM enclosure e LEFT JOIN playback_position p ON p.enclosureID = e.id WHERE e.articleID = a.id), '[]'),
+		COALESCE((SELECT json_agg(json_build_object('kind', n.kind, 'exact', n.exact, 'prefix', n.prefix,
+			'suffix', n.suffix, 'start', n.start_offset, 'end', n.end_offset, 'note', n.note, 'color', n.color,
+			'createdAt', n.created_at AT TIME ZONE 'UTC', 'updatedAt', n.updated_at AT TIME ZONE 'UTC') ORDER BY n.id)
+			FROM annotation n WHERE n.articleID = a.id), '[]'),
+		a.created_at, a.updated_at
+	FROM article a
+	JOIN rss r ON r.id = a.rssID
+	WHERE r.url IS NOT NULL
+	ORDER BY a.id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a BackupArticle
+		err := rows.Scan(&a.Feed, &a.Title, &a.Link, &a.GUID, &a.Description, &a.PublishDate, &a.Format,
+			&a.Identifier, &a.Author, &a.Categories, &a.Read, &a.Starred, &a.LeadImage, &a.ContentHash,
+			&a.URLKey, &a.SimHash, &a.ClusterID, &a.Tags, &a.Enclosures, &a.Annotations, &a.CreatedAt, &a.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Restore
+
+// Restorer merges backup records into the database in one transaction.
+// Records that already exist (matched by their natural key) are kept as
+// they are, except that articles become read or starred when they are in
+// the backup and playback positions only move forward. Restoring the same
+// backup twice changes nothing the second time.
+type Restorer struct {
+	ctx        context.Context
+	tx         pgx.Tx
+	categories map[string]int
+	feeds      map[string]int
+	webhooks   map[int]int // Webhook IDs in the backup to IDs here
+	clusters   map[int]int // Cluster IDs in the backup to IDs here
+}
+
+func BeginRestore(ctx context.Context) (*Restorer, error) {
+	tx, err := DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Restorer{
+		ctx:        ctx,
+		tx:         tx,
+		categories: map[string]int{},
+		feeds:      map[string]int{},
+		webhooks:   map[int]int{},
+		clusters:   map[int]int{},
+	}, nil
+}
+
+func (r *Restorer) Commit() error {
+	return r.tx.Commit(r.ctx)
+}
+
+// Rollback discards the restore. It does nothing after Commit.
+func (r *Restorer) Rollback() {
+	r.tx.Rollback(r.ctx)
+}
+
+// insertOrFind runs a query that returns the ID of either a newly inserted
+// row or the existing one, and whether it was inserted
+func (r *Restorer) insertOrFind(query string, args ...any) (int, bool, error) {
+	var id int
+	var inserted bool
+	err := r.tx.QueryRow(r.ctx, query, args...).Scan(&id, &inserted)
+	return id, inserted, err
+}
+
+// scope resolves the feed URL and category name a webhook or rule is
+// limited to
+func (r *Restorer) scope(feed, category string) (*int, *int, error) {
+	var rssID, categoryID *int
+	if feed != "" {
+		id, ok := r.feeds[feed]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown feed %q", feed)
+		}
+		rssID = &id
+	}
+	if category != "" {
+		id, ok := r.categories[category]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown category %q", category)
+		}
+		categoryID = &id
+	}
+	return rssID, categoryID, nil
+}
+
+// RestoreCategory adds a category unless one with its name exists
+func (r *Restorer) RestoreCategory(c BackupCategory) (bool, error) {
+	id, inserted, err := r.insertOrFind(`
+	WITH inserted AS (
+		INSERT INTO category (name, color) VALUES ($1, $2)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING id
+	)
+	SELECT id, true FROM inserted
+	UNION ALL
+	SELECT id, false FROM category WHERE name = $1
+	LIMIT 1`, c.Name, c.Color)
+	if err != nil {
+		return false, err
+	}
+	r.categories[c.Name] = id
+	return inserted, nil
+}
+
+// RestoreFeed adds a feed unless one with its URL exists
+func (r *Restorer) RestoreFeed(f BackupFeed) (bool, error) {
+	_, categoryID, err := r.scope("", f.Category)
+	if err != nil {
+		return false, err
+	}
+	if f.KeepParams == nil {
+		f.KeepParams = []string{}
+	}
+	if f.StripParams == nil {
+		f.StripParams = []string{}
+	}
+
+	id, inserted, err := r.insertOrFind(`
+	WITH inserted AS (
+		INSERT INTO rss (url, fiveURL, title, description, feedSize, sync, categoryID, url_keep_params, url_strip_params, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (url) DO NOTHING
+		RETURNING id
+	)
+	SELECT id, true FROM inserted
+	UNION ALL
+	SELECT id, false FROM rss WHERE url = $1
+	LIMIT 1`, f.URL, f.FiveURL, f.Title, f.Description, f.FeedSize, f.Sync, categoryID,
+		f.KeepParams, f.StripParams, f.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	r.feeds[f.URL] = id
+	return inserted, nil
+}
+
+// RestoreTag adds a tag unless it exists
+func (r *Restorer) RestoreTag(name string) (bool, error) {
+	result, err := r.tx.Exec(r.ctx, `INSERT INTO tag (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, name)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// RestoreWebhook adds a webhook unless one with the same URL and scope
+// exists. A webhook backed up without its secret is added inactive.
+func (r *Restorer) RestoreWebhook(w BackupWebhook) (bool, error) {
+	rssID, categoryID, err := r.scope(w.Feed, w.Category)
+	if err != nil {
+		return false, err
+	}
+
+	var id int
+	err = r.tx.QueryRow(r.ctx, `
+	SELECT id FROM webhook
+	WHERE url = $1 AND rssID IS NOT DISTINCT FROM $2 AND categoryID IS NOT DISTINCT FROM $3 AND keyword = $4
+	ORDER BY id
+	LIMIT 1`, w.URL, rssID, categoryID, w.Keyword).Scan(&id)
+	if err == nil {
+		r.webhooks[w.ID] = id
+		return false, nil
+	}
+	if err != pgx.ErrNoRows {
+		return false, err
+	}
+
+	err = r.tx.QueryRow(r.ctx, `
+	INSERT INTO webhook (url, secret, rssID, categoryID, keyword, active, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id`, w.URL, w.Secret, rssID, categoryID, w.Keyword, w.Active && !w.SecretOmitted, w.CreatedAt).Scan(&id)
+	if err != nil {
+		return false, err
+	}
+	r.webhooks[w.ID] = id
+	return true, nil
+}
+
+// RestoreRule adds a rule unless an identical one exists
+func (r *Restorer) RestoreRule(rule BackupRule) (bool, error) {
+	rssID, categoryID, err := r.scope(rule.Feed, rule.Category)
+	if err != nil {
+		return false, err
+	}
+	var webhookID *int
+	if rule.WebhookID != nil {
+		id, ok := r.webhooks[*rule.WebhookID]
+		if !ok {
+			return false, fmt.Errorf("unknown webhook %d", *rule.WebhookID)
+		}
+		webhookID = &id
+	}
+
+	result, err := r.tx.Exec(r.ctx, `
+	INSERT INTO rule (name, rssID, categoryID, field, match_type, pattern, action, webhookID, tag, enabled, position, created_at)
+	SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+	WHERE NOT EXISTS (
+		SELECT 1 FROM rule
+		WHERE name = $1 AND rssID IS NOT DISTINCT FROM $2 AND categoryID IS NOT DISTINCT FROM $3
+		AND field = $4 AND match_type = $5 AND pattern = $6 AND action = $7
+		AND webhookID IS NOT DISTINCT FROM $8 AND tag = $9
+	)`, rule.Name, rssID, categoryID, rule.Field, rule.MatchType, rule.Pattern, rule.Action,
+		webhookID, rule.Tag, rule.Enabled, rule.Position, rule.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// RestoreArticle adds an article unless its feed already has one with the
+// same link, then merges in its state, tags, enclosures and annotations.
+// It reports whether the article was added and how many annotations were.
+func (r *Restorer) RestoreArticle(a BackupArticle) (bool, int, error) {
+	rssID, ok := r.feeds[a.Feed]
+	if !ok {
+		return false, 0, fmt.Errorf("unknown feed %q", a.Feed)
+	}
+	if a.Categories == nil {
+		a.Categories = []string{}
+	}
+
+	var id int
+	var clusterID *int
+	var inserted bool
+	err := r.tx.QueryRow(r.ctx, `
+	INSERT INTO article (rssID, title, link, GUID, description, publishDate, format, identifier, author, categories,
+		read, starred, lead_image, content_hash, url_key, simhash, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, ''), NULLIF($15, ''), NULLIF($16::BIGINT, 0), $17, $18)
+	ON CONFLICT (rssID, link) DO UPDATE
+	SET read = COALESCE(article.read, false) OR EXCLUDED.read,
+		starred = article.starred OR EXCLUDED.starred,
+		lead_image = CASE WHEN article.lead_image = '' THEN EXCLUDED.lead_image ELSE article.lead_image END
+	RETURNING id, clusterID, xmax = 0`,
+		rssID, a.Title, a.Link, a.GUID, a.Description, a.PublishDate, a.Format, a.Identifier, a.Author, a.Categories,
+		a.Read, a.Starred, a.LeadImage, a.ContentHash, a.URLKey, a.SimHash, a.CreatedAt, a.UpdatedAt,
+	).Scan(&id, &clusterID, &inserted)
+	if err != nil {
+		return false, 0, err
+	}
+
+	// Clusters are identified by their first article, so the first article
+	// restored from a cluster decides its ID here
+	if a.ClusterID != nil {
+		target, ok := r.clusters[*a.ClusterID]
+		if !ok {
+			target = id
+			if clusterID != nil {
+				target = *clusterID
+			}
+			r.clusters[*a.ClusterID] = target
+		}
+		if clusterID == nil || *clusterID != target {
+			if _, err := r.tx.Exec(r.ctx, `UPDATE article SET clusterID = $1 WHERE id = $2`, target, id); err != nil {
+				return false, 0, err
+			}
+		}
+	}
+
+	if len(a.Tags) > 0 {
+		_, err = r.tx.Exec(r.ctx, `INSERT INTO tag (name) SELECT unnest($1::TEXT[]) ON CONFLICT (name) DO NOTHING`, a.Tags)
+		if err != nil {
+			return false, 0, err
+		}
+		_, err = r.tx.Exec(r.ctx, `
+		INSERT INTO article_tag (articleID, tagID)
+		SELECT $1, id FROM tag WHERE name = ANY($2)
+		ON CONFLICT DO NOTHING`, id, a.Tags)
+		if err != nil {
+			return false, 0, err
+		}
+	}
+
+	for _, e := range a.Enclosures {
+		enclosureID, _, err := r.insertOrFind(`
+		WITH inserted AS (
+			INSERT INTO enclosure (articleID, url, mime_type, length, duration, thumbnail_url)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (articleID, url) DO NOTHING
+			RETURNING id
+		)
+		SELECT id, true FROM inserted
+		UNION ALL
+		SELECT id, false FROM enclosure WHERE articleID = $1 AND url = $2
+		LIMIT 1`, id, e.URL, e.MimeType, e.Length, e.Duration, e.ThumbnailURL)
+		if err != nil {
+			return false, 0, err
+		}
+		if e.Position == 0 && !e.Completed {
+			continue
+		}
+		_, err = r.tx.Exec(r.ctx, `
+		INSERT INTO playback_position (enclosureID, position, completed)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (enclosureID) DO UPDATE
+		SET position = GREATEST(playback_position.position, EXCLUDED.position),
+			completed = playback_position.completed OR EXCLUDED.completed`, enclosureID, e.Position, e.Completed)
+		if err != nil {
+			return false, 0, err
+		}
+	}
+
+	annotations := 0
+	for _, n := range a.Annotations {
+		result, err := r.tx.Exec(r.ctx, `
+		INSERT INTO annotation (articleID, kind, exact, prefix, suffix, start_offset, end_offset, note, color, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		WHERE NOT EXISTS (
+			SELECT 1 FROM annotation
+			WHERE articleID = $1 AND kind = $2 AND exact = $3 AND start_offset = $6 AND end_offset = $7 AND note = $8
+		)`, id, n.Kind, n.Exact, n.Prefix, n.Suffix, n.Start, n.End, n.Note, n.Color, n.CreatedAt, n.UpdatedAt)
+		if err != nil {
+			return false, 0, err
+		}
+		annotations += int(result.RowsAffected())
+	}
+
+	return inserted, annotations, nil
+}
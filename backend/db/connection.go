@@ -3,7 +3,7 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -11,7 +11,16 @@ import (
 
 var DB *pgxpool.Pool
 
-func Init(datasource string) error {
+// PoolConfig sizes the connection pool
+type PoolConfig struct {
+	MaxConns          int32         // Maximum number of connections in the pool
+	MinConns          int32         // Minimum number of connections to maintain
+	MaxConnLifetime   time.Duration // Max lifetime of a connection
+	MaxConnIdleTime   time.Duration // Max idle time before closing
+	HealthCheckPeriod time.Duration // How often to check connection health
+}
+
+func Init(ctx context.Context, datasource string, pool PoolConfig) error {
 	// Parse the connection string into a config
 	config, err := pgxpool.ParseConfig(datasource)
 	if err != nil {
@@ -19,34 +28,66 @@ func Init(datasource string) error {
 	}
 
 	// Configure connection pool settings
-	config.MaxConns = 10                      // Maximum number of connections in the pool
-	config.MinConns = 2                       // Minimum number of connections to maintain
-	config.MaxConnLifetime = time.Hour        // Max lifetime of a connection
-	config.MaxConnIdleTime = time.Minute * 30 // Max idle time before closing
-	config.HealthCheckPeriod = time.Minute    // How often to check connection health
+	config.MaxConns = pool.MaxConns
+	config.MinConns = pool.MinConns
+	config.MaxConnLifetime = pool.MaxConnLifetime
+	config.MaxConnIdleTime = pool.MaxConnIdleTime
+	config.HealthCheckPeriod = pool.HealthCheckPeriod
 
 	// Create the connection pool with the configured settings
-	DB, err = pgxpool.NewWithConfig(context.Background(), config)
+	DB, err = pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		return fmt.Errorf("failed to create connection pool: %w", err)
 	}
 
 	// Test the connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	err = DB.Ping(ctx)
+	err = DB.Ping(pingCtx)
 	if err != nil {
 		DB.Close()
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	log.Printf("Database connected successfully with pool config: MaxConns=%d, MinConns=%d",
-		config.MaxConns, config.MinConns)
+	slog.InfoContext(ctx, "Database connected", "maxConns", config.MaxConns, "minConns", config.MinConns)
 
 	return nil
 }
 
+// Ping checks that a connection from the pool can reach the database
+func Ping(ctx context.Context) error {
+	return DB.Ping(ctx)
+}
+
+// PoolStats describes the connection pool
+type PoolStats struct {
+	MaxConns             int32   `json:"maxConns"`
+	TotalConns           int32   `json:"totalConns"`
+	IdleConns            int32   `json:"idleConns"`
+	AcquiredConns        int32   `json:"acquiredConns"`
+	ConstructingConns    int32   `json:"constructingConns"`
+	AcquireCount         int64   `json:"acquireCount"`
+	EmptyAcquireCount    int64   `json:"emptyAcquireCount"` // Acquires that had to wait for a connection
+	CanceledAcquireCount int64   `json:"canceledAcquireCount"`
+	AcquireDurationMs    float64 `json:"acquireDurationMs"` // Total time spent waiting for connections
+}
+
+func GetPoolStats() PoolStats {
+	stat := DB.Stat()
+	return PoolStats{
+		MaxConns:             stat.MaxConns(),
+		TotalConns:           stat.TotalConns(),
+		IdleConns:            stat.IdleConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		AcquireCount:         stat.AcquireCount(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		AcquireDurationMs:    float64(stat.AcquireDuration()) / float64(time.Millisecond),
+	}
+}
+
 func Close() {
 	DB.Close()
 }
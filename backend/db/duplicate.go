@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// DuplicateCandidate is a stored article an incoming one may duplicate
+type DuplicateCandidate struct {
+	ID        int
+	ClusterID *int
+	Read      bool
+	URLKey    string
+	SimHash   uint64
+}
+
+// SetArticleFingerprint stores the normalized URL key and content SimHash
+// of an article. A zero hash is stored as NULL.
+func SetArticleFingerprint(ctx context.Context, id int, urlKey string, simhash uint64) error {
+	_, err := DB.Exec(ctx,
+		`UPDATE article SET url_key = NULLIF($1, ''), simhash = $2 WHERE id = $3`, urlKey, simhashValue(simhash), id)
+	return err
+}
+
+// simhashValue is the column value of a SimHash: NULL for 0, otherwise the
+// bits as a signed BIGINT
+func simhashValue(simhash uint64) *int64 {
+	if simhash == 0 {
+		return nil
+	}
+	h := int64(simhash)
+	return &h
+}
+
+// ArticleLink is the stored link of an article
+type ArticleLink struct {
+	ID    int
+	RssID int
+	Link  string
+}
+
+// GetArticlesWithoutURLKey returns up to limit articles with an ID above
+// afterID that have no URL key, in ID order
+func GetArticlesWithoutURLKey(ctx context.Context, afterID, limit int) ([]ArticleLink, error) {
+	rows, err := DB.Query(ctx, `
+	SELECT id, rssID, COALESCE(link, '') FROM article
+	WHERE url_key IS NULL AND id > $1
+	ORDER BY id
+	LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var articles []ArticleLink
+	for rows.Next() {
+		var a ArticleLink
+		if err := rows.Scan(&a.ID, &a.RssID, &a.Link); err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
+
+// SetArticleURLKey stores the normalized URL key of an article
+func SetArticleURLKey(ctx context.Context, id int, urlKey string) error {
+	_, err := DB.Exec(ctx, `UPDATE article SET url_key = $1 WHERE id = $2`, urlKey, id)
+	return err
+}
+
+// GetDuplicateCandidates returns articles from other feeds that have the
+// same URL key, or a fingerprint and were stored since the given time
+func GetDuplicateCandidates(ctx context.Context, rssID int, urlKey string, since time.Time) ([]DuplicateCandidate, error) {
+	query := `
+	SELECT id, clusterID, COALESCE(read, false), COALESCE(url_key, ''), COALESCE(simhash, 0)
+	FROM article
+	WHERE rssID != $1
+	AND ((url_key IS NOT NULL AND url_key = $2) OR (simhash IS NOT NULL AND created_at >= $3))
+	ORDER BY id
+	`
+
+	rows, err := DB.Query(ctx, query, rssID, urlKey, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []DuplicateCandidate
+	for rows.Next() {
+		var c DuplicateCandidate
+		var hash int64
+		if err := rows.Scan(&c.ID, &c.ClusterID, &c.Read, &c.URLKey, &hash); err != nil {
+			return nil, err
+		}
+		c.SimHash = uint64(hash)
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// JoinCluster puts an article in the same cluster as an existing one. The
+// cluster is identified by the ID of its first article.
+func JoinCluster(ctx context.Context, articleID int, existing DuplicateCandidate) (int, error) {
+	clusterID := existing.ID
+	if existing.ClusterID != nil {
+		clusterID = *existing.ClusterID
+	}
+
+	_, err := DB.Exec(ctx,
+		`UPDATE article SET clusterID = $1 WHERE id = $2 OR id = $3`, clusterID, articleID, existing.ID)
+	return clusterID, err
+}
+
+// GetClusterMembers returns all articles in the given clusters, keyed by
+// cluster ID
+func GetClusterMembers(ctx context.Context, clusterIDs []int) (map[int][]ArticleRef, error) {
+	members := map[int][]ArticleRef{}
+	if len(clusterIDs) == 0 {
+		return members, nil
+	}
+
+	rows, err := DB.Query(ctx, `
+	SELECT clusterID, id, rssID, COALESCE(title, ''), COALESCE(link, '')
+	FROM article
+	WHERE clusterID = ANY($1)
+	ORDER BY id`, clusterIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var clusterID int
+		var ref ArticleRef
+		if err := rows.Scan(&clusterID, &ref.ID, &ref.RssID, &ref.Title, &ref.Link); err != nil {
+			return nil, err
+		}
+		members[clusterID] = append(members[clusterID], ref)
+	}
+	return members, rows.Err()
+}
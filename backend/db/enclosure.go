@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Enclosure is a media file attached to an article (a podcast episode, a
+// video). Position and Completed track playback; there is one playback
+// position per enclosure, shared by every device. Like Article, which
+// embeds it, it is serialized with its field names.
+type Enclosure struct {
+	ID                int
+	ArticleID         int
+	URL               string
+	MimeType          string
+	Length            int64 // Bytes, 0 if unknown
+	Duration          int   // Seconds, 0 if unknown
+	ThumbnailURL      string
+	Position          int // Seconds played
+	Completed         bool
+	PositionUpdatedAt *time.Time
+}
+
+func CreateEnclosureTables(ctx context.Context) error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS enclosure (
+	id SERIAL PRIMARY KEY,
+	articleID INT NOT NULL REFERENCES article(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	url TEXT NOT NULL,
+	mime_type TEXT NOT NULL DEFAULT '',
+	length BIGINT NOT NULL DEFAULT 0,
+	duration INT NOT NULL DEFAULT 0,
+	thumbnail_url TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+	CONSTRAINT unique_enclosure_article_url UNIQUE (articleID, url)
+	)`, `
+	CREATE TABLE IF NOT EXISTS playback_position (
+	enclosureID INT PRIMARY KEY REFERENCES enclosure(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	position INT NOT NULL DEFAULT 0,
+	completed BOOLEAN NOT NULL DEFAULT false,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	}
+
+	for _, query := range queries {
+		if _, err := DB.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enclosureJSON builds the JSON of an enclosure row e joined with its
+// playback position p, matching the Enclosure field names. Used to embed
+// enclosures in article rows.
+const enclosureJSON = `json_build_object('ID', e.id, 'ArticleID', e.articleID, 'URL', e.url, 'MimeType', e.mime_type,
+	'Length', e.length, 'Duration', e.duration, 'ThumbnailURL', e.thumbnail_url,
+	'Position', COALESCE(p.position, 0), 'Completed', COALESCE(p.completed, false), 'PositionUpdatedAt', p.updated_at AT TIME ZONE 'UTC')`
+
+// AddEnclosures stores the enclosures of a newly created article
+func AddEnclosures(ctx context.Context, articleID int, enclosures []Enclosure) error {
+	for _, e := range enclosures {
+		_, err := DB.Exec(ctx, `
+		INSERT INTO enclosure (articleID, url, mime_type, length, duration, thumbnail_url)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (articleID, url) DO NOTHING`,
+			articleID, e.URL, e.MimeType, e.Length, e.Duration, e.ThumbnailURL)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetEnclosureByID returns an enclosure with its playback position, or nil
+// when it doesn't exist
+func GetEnclosureByID(ctx context.Context, id int) (*Enclosure, error) {
+	e := &Enclosure{}
+	err := DB.QueryRow(ctx, `
+	SELECT e.id, e.articleID, e.url, e.mime_type, e.length, e.duration, e.thumbnail_url,
+		COALESCE(p.position, 0), COALESCE(p.completed, false), p.updated_at
+	FROM enclosure e
+	LEFT JOIN playback_position p ON p.enclosureID = e.id
+	WHERE e.id = $1`, id).Scan(&e.ID, &e.ArticleID, &e.URL, &e.MimeType, &e.Length, &e.Duration,
+		&e.ThumbnailURL, &e.Position, &e.Completed, &e.PositionUpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// UpdatePlaybackPosition saves how far an enclosure has been played
+func UpdatePlaybackPosition(ctx context.Context, enclosureID, position int, completed bool) error {
+	result, err := DB.Exec(ctx, `
+	INSERT INTO playback_position (enclosureID, position, completed)
+	SELECT id, $2, $3 FROM enclosure WHERE id = $1
+	ON CONFLICT (enclosureID) DO UPDATE
+	SET position = EXCLUDED.position, completed = EXCLUDED.completed, updated_at = CURRENT_TIMESTAMP`,
+		enclosureID, position, completed)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("enclosure with ID %d not found", enclosureID)
+	}
+	return nil
+}
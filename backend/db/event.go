@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// EventLogSize is the number of events kept for Last-Event-ID resume
+const EventLogSize = 1000
+
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func CreateEventTable(ctx context.Context) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS event (
+	id BIGSERIAL PRIMARY KEY,
+	type TEXT NOT NULL,
+	data JSONB NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+	_, err := DB.Exec(ctx, query)
+	return err
+}
+
+// CreateEvent appends an event to the log
+func CreateEvent(ctx context.Context, eventType string, data json.RawMessage) (*Event, error) {
+	query := `
+	INSERT INTO event (type, data)
+	VALUES ($1, $2)
+	RETURNING id, type, data, created_at`
+
+	event := &Event{}
+	err := DB.QueryRow(ctx, query, eventType, data).Scan(
+		&event.ID, &event.Type, &event.Data, &event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// TrimEvents deletes all but the newest EventLogSize events
+func TrimEvents(ctx context.Context) error {
+	_, err := DB.Exec(ctx, `DELETE FROM event WHERE id <= (SELECT MAX(id) FROM event) - $1`, EventLogSize)
+	return err
+}
+
+// GetEventsAfter returns the logged events with an ID greater than id, oldest first
+func GetEventsAfter(ctx context.Context, id int64) ([]Event, error) {
+	query := `
+	SELECT id, type, data, created_at
+	FROM event
+	WHERE id > $1
+	ORDER BY id`
+
+	rows, err := DB.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// GetOldestEventID returns the ID of the oldest event still in the log, or 0
+// when the log is empty
+func GetOldestEventID(ctx context.Context) (int64, error) {
+	var id int64
+	err := DB.QueryRow(ctx, `SELECT COALESCE(MIN(id), 0) FROM event`).Scan(&id)
+	return id, err
+}
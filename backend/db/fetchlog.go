@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// FetchLog records one attempt at fetching a feed
+type FetchLog struct {
+	ID            int64
+	RssID         int
+	StartedAt     time.Time
+	DurationMs    int64
+	StatusCode    *int // Nil when no response was received
+	Bytes         int64
+	ItemsSeen     int
+	ItemsInserted int
+	Error         string `json:",omitempty"`
+}
+
+func CreateFetchLogTable(ctx context.Context) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS fetch_log (
+	id BIGSERIAL PRIMARY KEY,
+	rss_id INT NOT NULL REFERENCES rss(id) ON DELETE CASCADE,
+	started_at TIMESTAMP NOT NULL,
+	duration_ms BIGINT NOT NULL,
+	status_code INT,
+	bytes BIGINT NOT NULL DEFAULT 0,
+	items_seen INT NOT NULL DEFAULT 0,
+	items_inserted INT NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS fetch_log_rss_started_idx ON fetch_log (rss_id, started_at DESC)`
+	_, err := DB.Exec(ctx, query)
+	return err
+}
+
+// AddFetchLog records a fetch and deletes the feed's entries that started
+// before keepSince
+func AddFetchLog(ctx context.Context, entry *FetchLog, keepSince time.Time) error {
+	query := `
+	INSERT INTO fetch_log (rss_id, started_at, duration_ms, status_code, bytes, items_seen, items_inserted, error)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id`
+
+	err := DB.QueryRow(ctx, query, entry.RssID, entry.StartedAt, entry.DurationMs, entry.StatusCode,
+		entry.Bytes, entry.ItemsSeen, entry.ItemsInserted, entry.Error).Scan(&entry.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(ctx, `DELETE FROM fetch_log WHERE rss_id = $1 AND started_at < $2`, entry.RssID, keepSince)
+	return err
+}
+
+// GetFetchLog returns up to limit of a feed's fetches that started before
+// the given time, newest first. A zero before returns the newest.
+func GetFetchLog(ctx context.Context, rssID int, before time.Time, limit int) ([]FetchLog, error) {
+	query := `
+	SELECT id, rss_id, started_at, duration_ms, status_code, bytes, items_seen, items_inserted, error
+	FROM fetch_log
+	WHERE rss_id = $1 AND ($2::timestamp IS NULL OR started_at < $2)
+	ORDER BY started_at DESC, id DESC
+	LIMIT $3`
+
+	var beforeParam *time.Time
+	if !before.IsZero() {
+		beforeParam = &before
+	}
+	rows, err := DB.Query(ctx, query, rssID, beforeParam, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fetches := []FetchLog{}
+	for rows.Next() {
+		var f FetchLog
+		err := rows.Scan(&f.ID, &f.RssID, &f.StartedAt, &f.DurationMs, &f.StatusCode,
+			&f.Bytes, &f.ItemsSeen, &f.ItemsInserted, &f.Error)
+		if err != nil {
+			return nil, err
+		}
+		fetches = append(fetches, f)
+	}
+	return fetches, rows.Err()
+}
+
+// FetchHistory summarizes a feed's recorded fetches
+type FetchHistory struct {
+	LastFetch            *FetchLog  `json:"last_fetch"`
+	LastSuccessfulFetch  *time.Time `json:"last_successful_fetch"`
+	LastNewItems         *time.Time `json:"last_new_items"` // Last fetch that stored new articles
+	FetchesLastDay       int        `json:"fetches_last_day"`
+	FailedFetchesLastDay int        `json:"failed_fetches_last_day"`
+}
+
+// GetFetchHistory summarizes the fetches recorded for a feed
+func GetFetchHistory(ctx context.Context, rssID int) (*FetchHistory, error) {
+	history := &FetchHistory{}
+
+	last, err := GetFetchLog(ctx, rssID, time.Time{}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(last) > 0 {
+		history.LastFetch = &last[0]
+	}
+
+	query := `
+	SELECT
+		MAX(started_at) FILTER (WHERE error = ''),
+		MAX(started_at) FILTER (WHERE items_inserted > 0),
+		COUNT(*) FILTER (WHERE started_at > CURRENT_TIMESTAMP - INTERVAL '1 day'),
+		COUNT(*) FILTER (WHERE started_at > CURRENT_TIMESTAMP - INTERVAL '1 day' AND error != '')
+	FROM fetch_log
+	WHERE rss_id = $1`
+	err = DB.QueryRow(ctx, query, rssID).Scan(&history.LastSuccessfulFetch, &history.LastNewItems,
+		&history.FetchesLastDay, &history.FailedFetchesLastDay)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ArticleRevision is the title and content an article had before the
+// publisher edited it
+type ArticleRevision struct {
+	ID          int
+	ArticleID   int
+	Title       string
+	Description string
+	CreatedAt   time.Time // When the revision was replaced
+}
+
+// StoredItem is what ingestion needs to know about an article that's
+// already stored to decide whether a feed item changed
+type StoredItem struct {
+	ID          int
+	Title       string
+	Description string
+	ContentHash string
+}
+
+func CreateArticleRevisionTable(ctx context.Context) error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS article_revision (
+	id SERIAL PRIMARY KEY,
+	articleID INT NOT NULL REFERENCES article(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	title TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE INDEX IF NOT EXISTS idx_article_revision_article ON article_revision (articleID)`,
+	}
+
+	for _, query := range queries {
+		if _, err := DB.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindStoredItem looks up the stored copy of a feed item: by GUID when the
+// item has one, otherwise by normalized link (or the exact link for
+// articles stored before URL keys existed). It returns nil when the item is
+// new.
+func FindStoredItem(ctx context.Context, rssID int, guid, urlKey, link string) (*StoredItem, error) {
+	query := `
+	SELECT id, COALESCE(title, ''), COALESCE(description, ''), COALESCE(content_hash, '')
+	FROM article
+	WHERE rssID = $1
+	AND (
+		($2 != '' AND GUID = $2)
+		OR ($2 = '' AND (($3 != '' AND url_key = $3) OR link = $4))
+	)
+	ORDER BY id
+	LIMIT 1
+	`
+
+	item := &StoredItem{}
+	err := DB.QueryRow(ctx, query, rssID, guid, urlKey, link).
+		Scan(&item.ID, &item.Title, &item.Description, &item.ContentHash)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// SetArticleContentHash records the hash of the feed item an article was
+// last stored from, along with the item's author and categories (which
+// older articles were stored without)
+func SetArticleContentHash(ctx context.Context, id int, hash, author string, categories []string) error {
+	_, err := DB.Exec(ctx,
+		`UPDATE article SET content_hash = $1, author = $2, categories = $3 WHERE id = $4`, hash, author, categories, id)
+	return err
+}
+
+// UpdateArticleContent replaces an article's title, content, author and
+// categories after the publisher edited it, saving the previous version as
+// a revision when keepRevision is set. The URL key and SimHash are replaced
+// along with the content, and the lead image is cleared to be looked up
+// again.
+func UpdateArticleContent(ctx context.Context, id int, title, description, author string, categories []string, hash string,
+	urlKey string, simhash uint64, keepRevision bool) error {
+	tx, err := DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if keepRevision {
+		_, err = tx.Exec(ctx, `
+		INSERT INTO article_revision (articleID, title, description)
+		SELECT id, COALESCE(title, ''), COALESCE(description, '') FROM article WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+	UPDATE article
+	SET title = $1, description = $2, author = $3, categories = $4, content_hash = $5,
+		url_key = NULLIF($6, ''), simhash = $7, lead_image = '', updated_at = CURRENT_TIMESTAMP
+	WHERE id = $8`, title, description, author, categories, hash, urlKey, simhashValue(simhash), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetArticleRevisions returns an article's previous versions, newest first
+func GetArticleRevisions(ctx context.Context, articleID int) ([]ArticleRevision, error) {
+	rows, err := DB.Query(ctx, `
+	SELECT id, articleID, title, description, created_at
+	FROM article_revision
+	WHERE articleID = $1
+	ORDER BY created_at DESC, id DESC`, articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []ArticleRevision
+	for rows.Next() {
+		var rev ArticleRevision
+		if err := rows.Scan(&rev.ID, &rev.ArticleID, &rev.Title, &rev.Description, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
@@ -3,7 +3,7 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -30,7 +30,7 @@ type Category struct {
 	UpdatedAt time.Time
 }
 
-func CreateCategoryTable() error {
+func CreateCategoryTable(ctx context.Context) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS category (
 	id SERIAL PRIMARY KEY,
@@ -41,13 +41,13 @@ func CreateCategoryTable() error {
 
 	CONSTRAINT unique_category_name UNIQUE (name)
 	)`
-	_, err := DB.Exec(context.Background(), query)
+	_, err := DB.Exec(ctx, query)
 	return err
 }
 
-func CreateRSSTable() error {
+func CreateRSSTable(ctx context.Context) error {
 	// First ensure category table exists
-	if err := CreateCategoryTable(); err != nil {
+	if err := CreateCategoryTable(ctx); err != nil {
 		return err
 	}
 
@@ -72,15 +72,54 @@ func CreateRSSTable() error {
 		ON DELETE SET NULL 
 		ON UPDATE CASCADE
 	)`
-	_, err := DB.Exec(context.Background(), query)
+	_, err := DB.Exec(ctx, query)
 	if err != nil {
 		return err
 	}
 
+	// Columns added after the initial schema
+	queries := []string{
+		`ALTER TABLE rss ADD COLUMN IF NOT EXISTS url_keep_params TEXT[] NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE rss ADD COLUMN IF NOT EXISTS url_strip_params TEXT[] NOT NULL DEFAULT '{}'`,
+	}
+	for _, query := range queries {
+		if _, err := DB.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FeedURLRules adjust link canonicalization for one feed: Keep lists query
+// parameters that must not be stripped even though they look like tracking,
+// Strip lists extra parameters to remove
+type FeedURLRules struct {
+	Keep  []string `json:"keep"`
+	Strip []string `json:"strip"`
+}
+
+func GetFeedURLRules(ctx context.Context, id int) (*FeedURLRules, error) {
+	rules := &FeedURLRules{}
+	err := DB.QueryRow(ctx,
+		`SELECT url_keep_params, url_strip_params FROM rss WHERE id = $1`, id).Scan(&rules.Keep, &rules.Strip)
+	return rules, err
+}
+
+func UpdateFeedURLRules(ctx context.Context, id int, rules *FeedURLRules) error {
+	result, err := DB.Exec(ctx,
+		`UPDATE rss SET url_keep_params = $1, url_strip_params = $2 WHERE id = $3`, rules.Keep, rules.Strip, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("RSS with ID %d not found", id)
+	}
 	return nil
 }
 
-func CreateRSS(url, fiveURL, title, description string, feedSize, sync int) (*RSS, error) {
+func CreateRSS(ctx context.Context, url, fiveURL, title, description string, feedSize, sync int) (*RSS, error) {
 	query := `
 	INSERT INTO rss (url, fiveURL, title, description, feedSize, sync, categoryID) 
 	VALUES ($1, $2, $3, $4, $5, $6, NULL)
@@ -88,7 +127,7 @@ func CreateRSS(url, fiveURL, title, description string, feedSize, sync int) (*RS
 	RETURNING id, url, fiveURL, title, description, feedSize, sync, categoryID, created_at, updated_at`
 
 	rss := &RSS{}
-	err := DB.QueryRow(context.Background(), query, url, fiveURL, title, description, feedSize, sync).Scan(
+	err := DB.QueryRow(ctx, query, url, fiveURL, title, description, feedSize, sync).Scan(
 		&rss.ID, &rss.URL, &rss.FiveURL, &rss.Title, &rss.Description, &rss.FeedSize, &rss.Sync, &rss.CategoryID,
 		&rss.CreatedAt, &rss.UpdatedAt,
 	)
@@ -101,13 +140,13 @@ func CreateRSS(url, fiveURL, title, description string, feedSize, sync int) (*RS
 	return rss, err
 }
 
-func GetAllRSS() ([]RSS, error) {
+func GetAllRSS(ctx context.Context) ([]RSS, error) {
 	query := `
 	SELECT id, url, fiveurl, title, description, feedSize, sync, categoryID, created_at, updated_at 
 	FROM rss 
 	ORDER BY categoryID NULLS FIRST, id`
 
-	rows, err := DB.Query(context.Background(), query)
+	rows, err := DB.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +165,7 @@ func GetAllRSS() ([]RSS, error) {
 	return rssFeeds, rows.Err()
 }
 
-func GetRSSByID(id int) (*RSS, error) {
+func GetRSSByID(ctx context.Context, id int) (*RSS, error) {
 	query := `
 	SELECT id, url, fiveurl, title, description, feedSize, sync, categoryID, created_at, updated_at
 	FROM rss
@@ -134,18 +173,38 @@ func GetRSSByID(id int) (*RSS, error) {
 	`
 
 	rss := &RSS{}
-	err := DB.QueryRow(context.Background(), query, id).Scan(&rss.ID, &rss.URL, &rss.FiveURL,
+	err := DB.QueryRow(ctx, query, id).Scan(&rss.ID, &rss.URL, &rss.FiveURL,
 		&rss.Title, &rss.Description, &rss.FeedSize, &rss.Sync, &rss.CategoryID, &rss.CreatedAt, &rss.UpdatedAt)
 
 	return rss, err
 }
 
-func DeleteRSSByID(id int) error {
+// GetRSSByURL returns the feed with the given URL, or nil if there is none
+func GetRSSByURL(ctx context.Context, url string) (*RSS, error) {
+	query := `
+	SELECT id, url, fiveurl, title, description, feedSize, sync, categoryID, created_at, updated_at
+	FROM rss
+	WHERE url = $1
+	`
+
+	rss := &RSS{}
+	err := DB.QueryRow(ctx, query, url).Scan(&rss.ID, &rss.URL, &rss.FiveURL,
+		&rss.Title, &rss.Description, &rss.FeedSize, &rss.Sync, &rss.CategoryID, &rss.CreatedAt, &rss.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rss, nil
+}
+
+func DeleteRSSByID(ctx context.Context, id int) error {
 	query := `
 	DELETE FROM rss WHERE id = $1
 	`
 
-	result, err := DB.Exec(context.Background(), query, id)
+	result, err := DB.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -157,16 +216,16 @@ func DeleteRSSByID(id int) error {
 
 	// Reset the sequence after successful deletion
 	resetQuery := `SELECT setval('rss_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM rss`
-	_, err = DB.Exec(context.Background(), resetQuery)
+	_, err = DB.Exec(ctx, resetQuery)
 	if err != nil {
 		// Log the error but don't fail the function since the delete succeeded
-		log.Printf("Warning: failed to reset rss sequence: %v", err)
+		slog.WarnContext(ctx, "Failed to reset rss sequence", "error", err)
 	}
 
 	return nil
 }
 
-func UpdateRSS[T string | int](id int, param string, value T) error {
+func UpdateRSS[T string | int](ctx context.Context, id int, param string, value T) error {
 	var query string
 
 	switch param {
@@ -184,7 +243,7 @@ func UpdateRSS[T string | int](id int, param string, value T) error {
 		return fmt.Errorf("invalid parameter: %s", param)
 	}
 
-	result, err := DB.Exec(context.Background(), query, value, id)
+	result, err := DB.Exec(ctx, query, value, id)
 	if err != nil {
 		return err
 	}
@@ -197,12 +256,11 @@ func UpdateRSS[T string | int](id int, param string, value T) error {
 }
 
 // Special function for updating categoryID that handles NULL values
-func UpdateRSSCategoryID(id int, categoryID *int) error {
+func UpdateRSSCategoryID(ctx context.Context, id int, categoryID *int) error {
 	query := `UPDATE rss SET categoryID = $1 WHERE id = $2`
 
-	result, err := DB.Exec(context.Background(), query, categoryID, id)
+	result, err := DB.Exec(ctx, query, categoryID, id)
 	if err != nil {
-		log.Printf("Error updating categoryID for RSS %d: %v", id, err)
 		return err
 	}
 
@@ -211,7 +269,6 @@ func UpdateRSSCategoryID(id int, categoryID *int) error {
 		return fmt.Errorf("RSS with ID %d not found", id)
 	}
 
-	log.Printf("Successfully updated RSS %d categoryID to %v", id, categoryID)
 	return nil
 }
 
@@ -224,20 +281,21 @@ type RSSStats struct {
 	NewestArticle     time.Time `json:"newest_article"`
 	LastUpdated       time.Time `json:"last_updated"`
 	DaysSinceLastPost int       `json:"days_since_last_post"`
+	*FetchHistory
 }
 
-func GetRSSStats(id int) (*RSSStats, error) {
+func GetRSSStats(ctx context.Context, id int) (*RSSStats, error) {
 	stats := &RSSStats{FeedID: id}
 
 	// Get total article count
-	err := DB.QueryRow(context.Background(),
+	err := DB.QueryRow(ctx,
 		"SELECT COUNT(*) FROM article WHERE rssid = $1", id).Scan(&stats.TotalArticles)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get unread article count
-	err = DB.QueryRow(context.Background(),
+	err = DB.QueryRow(ctx,
 		"SELECT COUNT(*) FROM article WHERE rssid = $1 AND read = false", id).Scan(&stats.UnreadArticles)
 	if err != nil {
 		return nil, err
@@ -248,7 +306,7 @@ func GetRSSStats(id int) (*RSSStats, error) {
 
 	// Get oldest and newest article dates (return early if no articles)
 	if stats.TotalArticles > 0 {
-		err = DB.QueryRow(context.Background(),
+		err = DB.QueryRow(ctx,
 			"SELECT MIN(created_at), MAX(created_at) FROM article WHERE rssid = $1",
 			id).Scan(&stats.OldestArticle, &stats.NewestArticle)
 		if err != nil {
@@ -257,7 +315,7 @@ func GetRSSStats(id int) (*RSSStats, error) {
 
 		// Get days since last post (using publishDate if available, otherwise created_at)
 		var lastPostDate time.Time
-		err = DB.QueryRow(context.Background(), `
+		err = DB.QueryRow(ctx, `
 			SELECT COALESCE(MAX(
 				CASE 
 					WHEN publishdate != '' AND publishdate IS NOT NULL 
@@ -274,18 +332,23 @@ func GetRSSStats(id int) (*RSSStats, error) {
 	}
 
 	// Get RSS feed last updated time
-	err = DB.QueryRow(context.Background(),
+	err = DB.QueryRow(ctx,
 		"SELECT updated_at FROM rss WHERE id = $1", id).Scan(&stats.LastUpdated)
 	if err != nil {
 		return nil, err
 	}
 
+	stats.FetchHistory, err = GetFetchHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
 	return stats, nil
 }
 
 // Category management functions
 
-func CreateCategory(name, color string) (*Category, error) {
+func CreateCategory(ctx context.Context, name, color string) (*Category, error) {
 	query := `
 	INSERT INTO category (name, color) 
 	VALUES ($1, $2)
@@ -293,7 +356,7 @@ func CreateCategory(name, color string) (*Category, error) {
 	RETURNING id, name, color, created_at, updated_at`
 
 	category := &Category{}
-	err := DB.QueryRow(context.Background(), query, name, color).Scan(
+	err := DB.QueryRow(ctx, query, name, color).Scan(
 		&category.ID, &category.Name, &category.Color, &category.CreatedAt, &category.UpdatedAt,
 	)
 
@@ -304,13 +367,13 @@ func CreateCategory(name, color string) (*Category, error) {
 	return category, err
 }
 
-func GetAllCategories() ([]Category, error) {
+func GetAllCategories(ctx context.Context) ([]Category, error) {
 	query := `
 	SELECT id, name, color, created_at, updated_at 
 	FROM category 
 	ORDER BY name`
 
-	rows, err := DB.Query(context.Background(), query)
+	rows, err := DB.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -328,7 +391,7 @@ func GetAllCategories() ([]Category, error) {
 	return categories, rows.Err()
 }
 
-func GetCategoryByID(id int) (*Category, error) {
+func GetCategoryByID(ctx context.Context, id int) (*Category, error) {
 	query := `
 	SELECT id, name, color, created_at, updated_at
 	FROM category
@@ -336,15 +399,35 @@ func GetCategoryByID(id int) (*Category, error) {
 	`
 
 	category := &Category{}
-	err := DB.QueryRow(context.Background(), query, id).Scan(&category.ID, &category.Name, &category.Color, &category.CreatedAt, &category.UpdatedAt)
+	err := DB.QueryRow(ctx, query, id).Scan(&category.ID, &category.Name, &category.Color, &category.CreatedAt, &category.UpdatedAt)
 
 	return category, err
 }
 
-func UpdateCategory(id int, name, color string) error {
+// GetCategoryByName returns the category with the given name, or nil if
+// there is none
+func GetCategoryByName(ctx context.Context, name string) (*Category, error) {
+	query := `
+	SELECT id, name, color, created_at, updated_at
+	FROM category
+	WHERE name = $1
+	`
+
+	category := &Category{}
+	err := DB.QueryRow(ctx, query, name).Scan(&category.ID, &category.Name, &category.Color, &category.CreatedAt, &category.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+func UpdateCategory(ctx context.Context, id int, name, color string) error {
 	query := `UPDATE category SET name = $1, color = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3`
 
-	result, err := DB.Exec(context.Background(), query, name, color, id)
+	result, err := DB.Exec(ctx, query, name, color, id)
 	if err != nil {
 		return err
 	}
@@ -356,16 +439,16 @@ func UpdateCategory(id int, name, color string) error {
 	return nil
 }
 
-func DeleteCategoryByID(id int) error {
+func DeleteCategoryByID(ctx context.Context, id int) error {
 	// First, set all RSS feeds in this category to NULL
-	_, err := DB.Exec(context.Background(), "UPDATE rss SET categoryID = NULL WHERE categoryID = $1", id)
+	_, err := DB.Exec(ctx, "UPDATE rss SET categoryID = NULL WHERE categoryID = $1", id)
 	if err != nil {
 		return err
 	}
 
 	// Then delete the category
 	query := `DELETE FROM category WHERE id = $1`
-	result, err := DB.Exec(context.Background(), query, id)
+	result, err := DB.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -378,7 +461,7 @@ func DeleteCategoryByID(id int) error {
 	return nil
 }
 
-func GetRSSByCategory(categoryID *int) ([]RSS, error) {
+func GetRSSByCategory(ctx context.Context, categoryID *int) ([]RSS, error) {
 	var query string
 	var args []interface{}
 
@@ -400,7 +483,7 @@ func GetRSSByCategory(categoryID *int) ([]RSS, error) {
 		args = []interface{}{*categoryID}
 	}
 
-	rows, err := DB.Query(context.Background(), query, args...)
+	rows, err := DB.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Rule is a filter evaluated against incoming articles. Scope works like
+// webhooks: with neither RssID nor CategoryID set the rule is global.
+type Rule struct {
+	ID         int
+	Name       string
+	RssID      *int
+	CategoryID *int
+	Field      string // title, content, author, link or any
+	MatchType  string // keyword or regex
+	Pattern    string
+	Action     string // skip, mark_read, star, tag or webhook
+	WebhookID  *int
+	Tag        string
+	Enabled    bool
+	Position   int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func CreateRuleTable(ctx context.Context) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS rule (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL DEFAULT '',
+	rssID INT REFERENCES rss(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	categoryID INT REFERENCES category(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	field TEXT NOT NULL,
+	match_type TEXT NOT NULL,
+	pattern TEXT NOT NULL,
+	action TEXT NOT NULL,
+	webhookID INT REFERENCES webhook(id) ON DELETE CASCADE,
+	enabled BOOLEAN NOT NULL DEFAULT true,
+	position INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+	_, err := DB.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema
+	_, err = DB.Exec(ctx, `ALTER TABLE rule ADD COLUMN IF NOT EXISTS tag TEXT NOT NULL DEFAULT ''`)
+	return err
+}
+
+const ruleColumns = `id, name, rssID, categoryID, field, match_type, pattern, action, webhookID, tag, enabled, position, created_at, updated_at`
+
+func scanRule(row pgx.Row) (Rule, error) {
+	var rule Rule
+	err := row.Scan(&rule.ID, &rule.Name, &rule.RssID, &rule.CategoryID, &rule.Field, &rule.MatchType,
+		&rule.Pattern, &rule.Action, &rule.WebhookID, &rule.Tag, &rule.Enabled, &rule.Position,
+		&rule.CreatedAt, &rule.UpdatedAt)
+	return rule, err
+}
+
+func queryRules(ctx context.Context, query string, args ...any) ([]Rule, error) {
+	rows, err := DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []Rule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func CreateRule(ctx context.Context, rule *Rule) (*Rule, error) {
+	query := `
+	INSERT INTO rule (name, rssID, categoryID, field, match_type, pattern, action, webhookID, tag, enabled, position)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING ` + ruleColumns
+
+	created, err := scanRule(DB.QueryRow(ctx, query, rule.Name, rule.RssID, rule.CategoryID,
+		rule.Field, rule.MatchType, rule.Pattern, rule.Action, rule.WebhookID, rule.Tag, rule.Enabled, rule.Position))
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func GetAllRules(ctx context.Context) ([]Rule, error) {
+	return queryRules(ctx, `SELECT `+ruleColumns+` FROM rule ORDER BY position, id`)
+}
+
+// GetRulesForFeed returns the enabled rules that apply to a feed: global
+// rules, rules for the feed's category and rules for the feed itself
+func GetRulesForFeed(ctx context.Context, rssID int) ([]Rule, error) {
+	return queryRules(ctx, `
+	SELECT `+ruleColumns+`
+	FROM rule
+	WHERE enabled
+	AND (rssID IS NULL OR rssID = $1)
+	AND (categoryID IS NULL OR categoryID = (SELECT categoryID FROM rss WHERE id = $1))
+	ORDER BY position, id`, rssID)
+}
+
+func GetRuleByID(ctx context.Context, id int) (*Rule, error) {
+	rule, err := scanRule(DB.QueryRow(ctx, `SELECT `+ruleColumns+` FROM rule WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// UpdateRule saves all editable fields of rule
+func UpdateRule(ctx context.Context, rule *Rule) error {
+	query := `
+	UPDATE rule
+	SET name = $1, rssID = $2, categoryID = $3, field = $4, match_type = $5, pattern = $6,
+		action = $7, webhookID = $8, tag = $9, enabled = $10, position = $11, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $12`
+
+	result, err := DB.Exec(ctx, query, rule.Name, rule.RssID, rule.CategoryID, rule.Field,
+		rule.MatchType, rule.Pattern, rule.Action, rule.WebhookID, rule.Tag, rule.Enabled, rule.Position, rule.ID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("rule with ID %d not found", rule.ID)
+	}
+	return nil
+}
+
+func DeleteRule(ctx context.Context, id int) error {
+	result, err := DB.Exec(ctx, `DELETE FROM rule WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("rule with ID %d not found", id)
+	}
+	return nil
+}
+
+// GetRecentArticlesInScope returns the newest articles a rule with the given
+// scope would have seen, for testing rules against existing data
+func GetRecentArticlesInScope(ctx context.Context, rssID, categoryID *int, limit int) ([]Article, error) {
+	query := `
+	SELECT ` + articleColumns + `
+	FROM article
+	WHERE ($1::INT IS NULL OR rssID = $1)
+	AND ($2::INT IS NULL OR rssID IN (SELECT id FROM rss WHERE categoryID = $2))
+	ORDER BY created_at DESC, id DESC
+	LIMIT $3
+	`
+
+	rows, err := DB.Query(ctx, query, rssID, categoryID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanArticles(rows)
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Settings are values the server generates for itself and must keep across
+// restarts, like the image proxy signing key
+
+func CreateSettingTable(ctx context.Context) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS setting (
+	name TEXT PRIMARY KEY,
+	value TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	_, err := DB.Exec(ctx, query)
+	return err
+}
+
+// GetOrCreateSetting returns the stored value of a setting, storing value
+// first if the setting doesn't exist yet
+func GetOrCreateSetting(ctx context.Context, name, value string) (string, error) {
+	_, err := DB.Exec(ctx, `INSERT INTO setting (name, value) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`, name, value)
+	if err != nil {
+		return "", err
+	}
+
+	var stored string
+	err = DB.QueryRow(ctx, `SELECT value FROM setting WHERE name = $1`, name).Scan(&stored)
+	return stored, err
+}
+
+// SchemaVersion is the version of the schema this build's migrations create.
+// Bump it when adding a migration, so instances whose database hasn't been
+// migrated yet report themselves as not ready.
+const SchemaVersion = 1
+
+const schemaVersionSetting = "schema_version"
+
+// MarkMigrated records in the database that the schema is at SchemaVersion.
+// A newer version recorded by a newer build is kept.
+func MarkMigrated(ctx context.Context) error {
+	_, err := DB.Exec(ctx, `
+	INSERT INTO setting (name, value) VALUES ($1, $2)
+	ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value
+	WHERE setting.value::int < EXCLUDED.value::int`, schemaVersionSetting, strconv.Itoa(SchemaVersion))
+	return err
+}
+
+// CheckSchema returns an error unless the database's schema has been
+// migrated to at least SchemaVersion
+func CheckSchema(ctx context.Context) error {
+	var value string
+	err := DB.QueryRow(ctx, `SELECT value FROM setting WHERE name = $1`, schemaVersionSetting).Scan(&value)
+	if err == pgx.ErrNoRows {
+		return errors.New("schema has not been created or updated")
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid schema version %q", value)
+	}
+	if version < SchemaVersion {
+		return fmt.Errorf("schema is at version %d, this build needs %d", version, SchemaVersion)
+	}
+	return nil
+}
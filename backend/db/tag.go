@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type Tag struct {
+	ID        int
+	Name      string
+	Count     int // Number of tagged articles
+	CreatedAt time.Time
+}
+
+func CreateTagTables(ctx context.Context) error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS tag (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+	CONSTRAINT unique_tag_name UNIQUE (name)
+	)`, `
+	CREATE TABLE IF NOT EXISTS article_tag (
+	articleID INT NOT NULL REFERENCES article(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	tagID INT NOT NULL REFERENCES tag(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (articleID, tagID)
+	)`, `
+	CREATE INDEX IF NOT EXISTS idx_article_tag_tag ON article_tag (tagID)`,
+	}
+
+	for _, query := range queries {
+		if _, err := DB.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAllTags returns every tag with the number of articles carrying it
+func GetAllTags(ctx context.Context) ([]Tag, error) {
+	query := `
+	SELECT t.id, t.name, COUNT(atg.articleID), t.created_at
+	FROM tag t
+	LEFT JOIN article_tag atg ON atg.tagID = t.id
+	GROUP BY t.id
+	ORDER BY t.name`
+
+	rows, err := DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Count, &tag.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func GetTagByID(ctx context.Context, id int) (*Tag, error) {
+	query := `
+	SELECT t.id, t.name, (SELECT COUNT(*) FROM article_tag WHERE tagID = t.id), t.created_at
+	FROM tag t
+	WHERE t.id = $1`
+
+	tag := &Tag{}
+	err := DB.QueryRow(ctx, query, id).Scan(&tag.ID, &tag.Name, &tag.Count, &tag.CreatedAt)
+	return tag, err
+}
+
+// AddArticleTag tags an article, creating the tag if it doesn't exist yet.
+// Tagging an article twice with the same tag is a no-op.
+func AddArticleTag(ctx context.Context, articleID int, name string) (*Tag, error) {
+	tx, err := DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag := &Tag{}
+	err = tx.QueryRow(ctx, `
+	INSERT INTO tag (name) VALUES ($1)
+	ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+	RETURNING id, name, created_at`, name).Scan(&tag.ID, &tag.Name, &tag.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(ctx, `
+	INSERT INTO article_tag (articleID, tagID)
+	SELECT id, $2 FROM article WHERE id = $1
+	ON CONFLICT DO NOTHING`, articleID, tag.ID)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected() == 0 {
+		var exists bool
+		err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM article WHERE id = $1)`, articleID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("article with ID %d not found", articleID)
+		}
+	}
+
+	return tag, tx.Commit(ctx)
+}
+
+func RemoveArticleTag(ctx context.Context, articleID, tagID int) error {
+	result, err := DB.Exec(ctx,
+		`DELETE FROM article_tag WHERE articleID = $1 AND tagID = $2`, articleID, tagID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("article %d is not tagged with tag %d", articleID, tagID)
+	}
+	return nil
+}
+
+func DeleteTag(ctx context.Context, id int) error {
+	result, err := DB.Exec(ctx, `DELETE FROM tag WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("tag with ID %d not found", id)
+	}
+	return nil
+}
+
+// GetArticlesByTag returns the newest articles carrying a tag
+func GetArticlesByTag(ctx context.Context, tagID, limit int) ([]Article, error) {
+	query := `
+	SELECT ` + articleColumns + `
+	FROM article
+	WHERE id IN (SELECT articleID FROM article_tag WHERE tagID = $1)
+	AND publishDate != '' AND publishDate IS NOT NULL
+	ORDER BY publishDate::TIMESTAMP DESC
+	LIMIT $2
+	`
+
+	rows, err := DB.Query(ctx, query, tagID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanArticles(rows)
+}
@@ -0,0 +1,352 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an outgoing subscription. The scope fields are optional and
+// combined: a webhook with both RssID and Keyword set only fires for
+// articles from that feed that contain the keyword.
+type Webhook struct {
+	ID         int
+	URL        string
+	Secret     string `json:"-"`
+	RssID      *int
+	CategoryID *int
+	Keyword    string
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type WebhookDelivery struct {
+	ID             int
+	WebhookID      int
+	ArticleID      *int
+	Event          string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int
+	LastStatusCode *int
+	LastError      string
+	NextAttemptAt  *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	AttemptLog     []WebhookAttempt `json:",omitempty"`
+}
+
+type WebhookAttempt struct {
+	ID         int
+	DeliveryID int
+	Attempt    int
+	StatusCode *int
+	Error      string
+	DurationMS int
+	CreatedAt  time.Time
+}
+
+func CreateWebhookTables(ctx context.Context) error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS webhook (
+	id SERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL DEFAULT '',
+	rssID INT REFERENCES rss(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	categoryID INT REFERENCES category(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	keyword TEXT NOT NULL DEFAULT '',
+	active BOOLEAN NOT NULL DEFAULT true,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE TABLE IF NOT EXISTS webhook_delivery (
+	id SERIAL PRIMARY KEY,
+	webhookID INT NOT NULL REFERENCES webhook(id) ON DELETE CASCADE,
+	articleID INT REFERENCES article(id) ON DELETE SET NULL,
+	event TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	last_status_code INT,
+	last_error TEXT NOT NULL DEFAULT '',
+	next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`, `
+	CREATE INDEX IF NOT EXISTS idx_webhook_delivery_due
+	ON webhook_delivery (next_attempt_at) WHERE status = 'pending'`, `
+	CREATE TABLE IF NOT EXISTS webhook_attempt (
+	id SERIAL PRIMARY KEY,
+	deliveryID INT NOT NULL REFERENCES webhook_delivery(id) ON DELETE CASCADE,
+	attempt INT NOT NULL,
+	status_code INT,
+	error TEXT NOT NULL DEFAULT '',
+	duration_ms INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`,
+	}
+
+	for _, query := range queries {
+		if _, err := DB.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const webhookColumns = `id, url, secret, rssID, categoryID, keyword, active, created_at, updated_at`
+
+func scanWebhook(row pgx.Row) (Webhook, error) {
+	var webhook Webhook
+	err := row.Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &webhook.RssID, &webhook.CategoryID,
+		&webhook.Keyword, &webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt)
+	return webhook, err
+}
+
+func queryWebhooks(ctx context.Context, query string, args ...any) ([]Webhook, error) {
+	rows, err := DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func CreateWebhook(ctx context.Context, url, secret string, rssID, categoryID *int, keyword string, active bool) (*Webhook, error) {
+	query := `
+	INSERT INTO webhook (url, secret, rssID, categoryID, keyword, active)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING ` + webhookColumns
+
+	webhook, err := scanWebhook(DB.QueryRow(ctx, query, url, secret, rssID, categoryID, keyword, active))
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func GetAllWebhooks(ctx context.Context) ([]Webhook, error) {
+	return queryWebhooks(ctx, `SELECT `+webhookColumns+` FROM webhook ORDER BY id`)
+}
+
+func GetActiveWebhooks(ctx context.Context) ([]Webhook, error) {
+	return queryWebhooks(ctx, `SELECT `+webhookColumns+` FROM webhook WHERE active ORDER BY id`)
+}
+
+func GetWebhookByID(ctx context.Context, id int) (*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhook WHERE id = $1`
+
+	webhook, err := scanWebhook(DB.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// UpdateWebhook saves all editable fields of webhook
+func UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	query := `
+	UPDATE webhook
+	SET url = $1, secret = $2, rssID = $3, categoryID = $4, keyword = $5, active = $6, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $7`
+
+	result, err := DB.Exec(ctx, query, webhook.URL, webhook.Secret, webhook.RssID,
+		webhook.CategoryID, webhook.Keyword, webhook.Active, webhook.ID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("webhook with ID %d not found", webhook.ID)
+	}
+	return nil
+}
+
+func DeleteWebhook(ctx context.Context, id int) error {
+	result, err := DB.Exec(ctx, `DELETE FROM webhook WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("webhook with ID %d not found", id)
+	}
+	return nil
+}
+
+// Deliveries
+
+const deliveryColumns = `id, webhookID, articleID, event, payload, status, attempts, last_status_code, last_error, next_attempt_at, created_at, updated_at`
+
+func scanDelivery(row pgx.Row) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.ArticleID, &delivery.Event, &delivery.Payload,
+		&delivery.Status, &delivery.Attempts, &delivery.LastStatusCode, &delivery.LastError,
+		&delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.UpdatedAt)
+	return delivery, err
+}
+
+func queryDeliveries(ctx context.Context, query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// CreateWebhookDelivery queues a payload for delivery as soon as possible
+func CreateWebhookDelivery(ctx context.Context, webhookID int, articleID *int, event string, payload json.RawMessage) (*WebhookDelivery, error) {
+	query := `
+	INSERT INTO webhook_delivery (webhookID, articleID, event, payload)
+	VALUES ($1, $2, $3, $4)
+	RETURNING ` + deliveryColumns
+
+	delivery, err := scanDelivery(DB.QueryRow(ctx, query, webhookID, articleID, event, payload))
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ClaimDueWebhookDeliveries returns pending deliveries whose next attempt is
+// due, pushing their next attempt back by lease so another worker doesn't
+// pick them up while they're being sent. A delivery whose attempt is never
+// recorded, because the process stopped, becomes due again once the lease
+// runs out.
+func ClaimDueWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	return queryDeliveries(ctx, `
+	UPDATE webhook_delivery
+	SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+	WHERE id IN (
+		SELECT id
+		FROM webhook_delivery
+		WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY next_attempt_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING `+deliveryColumns, limit, lease.Seconds())
+}
+
+// GetWebhookDeliveries lists the most recent deliveries for a webhook,
+// optionally filtered by status
+func GetWebhookDeliveries(ctx context.Context, webhookID int, status string, limit int) ([]WebhookDelivery, error) {
+	return queryDeliveries(ctx, `
+	SELECT `+deliveryColumns+`
+	FROM webhook_delivery
+	WHERE webhookID = $1 AND ($2 = '' OR status = $2)
+	ORDER BY id DESC
+	LIMIT $3`, webhookID, status, limit)
+}
+
+// GetWebhookDeliveryByID returns a delivery including its attempt log
+func GetWebhookDeliveryByID(ctx context.Context, id int) (*WebhookDelivery, error) {
+	delivery, err := scanDelivery(DB.QueryRow(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_delivery WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(ctx, `
+	SELECT id, deliveryID, attempt, status_code, error, duration_ms, created_at
+	FROM webhook_attempt
+	WHERE deliveryID = $1
+	ORDER BY attempt`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivery.AttemptLog = []WebhookAttempt{}
+	for rows.Next() {
+		var attempt WebhookAttempt
+		err := rows.Scan(&attempt.ID, &attempt.DeliveryID, &attempt.Attempt, &attempt.StatusCode,
+			&attempt.Error, &attempt.DurationMS, &attempt.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.AttemptLog = append(delivery.AttemptLog, attempt)
+	}
+	return &delivery, rows.Err()
+}
+
+// RecordWebhookAttempt logs one delivery attempt and moves the delivery to
+// its new status. A pending delivery is due again retryIn from now, counted
+// by the database clock like ClaimDueWebhookDeliveries so the time zone of
+// the column never matters.
+func RecordWebhookAttempt(ctx context.Context, deliveryID int, statusCode *int, errMsg string, duration time.Duration, status string, retryIn time.Duration) error {
+	tx, err := DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+	UPDATE webhook_delivery
+	SET attempts = attempts + 1, status = $1, last_status_code = $2, last_error = $3,
+		next_attempt_at = CASE WHEN $1 = 'pending' THEN CURRENT_TIMESTAMP + make_interval(secs => $4) END,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = $5`, status, statusCode, errMsg, retryIn.Seconds(), deliveryID)
+	if err != nil {
+		return err
+	}
+
+	// Attempts are numbered across replays, so count the log rather than
+	// using the delivery's attempts counter
+	_, err = tx.Exec(ctx, `
+	INSERT INTO webhook_attempt (deliveryID, attempt, status_code, error, duration_ms)
+	SELECT $1, COUNT(*) + 1, $2, $3, $4 FROM webhook_attempt WHERE deliveryID = $1`,
+		deliveryID, statusCode, errMsg, duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ResetWebhookDelivery queues a delivery to be sent again immediately with a
+// fresh retry budget. The attempt log is kept.
+func ResetWebhookDelivery(ctx context.Context, id int) error {
+	result, err := DB.Exec(ctx, `
+	UPDATE webhook_delivery
+	SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("webhook delivery with ID %d not found", id)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package dedupe
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/JonSchaeffer/go-reader/annotations"
+)
+
+// MaxDistance is the largest number of differing SimHash bits for two
+// articles to count as near-duplicates
+const MaxDistance = 3
+
+// minWords is the least amount of text a fingerprint is computed for. Short
+// bodies (a title and a "read more" link) look alike across unrelated posts.
+const minWords = 40
+
+// shingleSize is the number of consecutive words hashed together
+const shingleSize = 3
+
+// URLKey reduces a link, already cleaned up by rss.CanonicalURL, to a key
+// that's equal for the same page reached through different feeds: scheme,
+// "www." and trailing slash are dropped and the query sorted. It is only a
+// comparison key, never used as a link.
+func URLKey(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	path := strings.TrimSuffix(u.EscapedPath(), "/")
+
+	key := host + path
+	if encoded := u.Query().Encode(); encoded != "" {
+		key += "?" + encoded
+	}
+	return key
+}
+
+// SimHash returns a 64-bit SimHash of the visible text of article HTML, or 0
+// when there's too little text to fingerprint reliably. Texts that differ
+// only slightly (a different footer, a fixed typo) get hashes a few bits
+// apart.
+func SimHash(content string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(annotations.PlainText(content)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) < minWords {
+		return 0
+	}
+
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		sum := h.Sum64()
+		for bit := range weights {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash
+}
+
+// Distance is the number of bits two SimHashes differ in
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Similar reports whether two SimHashes are near-duplicates. A zero hash
+// (too little text) is never similar to anything.
+func Similar(a, b uint64) bool {
+	return a != 0 && b != 0 && Distance(a, b) <= MaxDistance
+}
@@ -0,0 +1,25 @@
+package dedupe
+
+import "testing"
+
+func TestURLKey(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{"scheme and www", "https://www.Example.com/post/", "example.com/post"},
+		{"same page over http", "http://example.com/post", "example.com/post"},
+		{"sorted query", "https://example.com/post?b=2&a=1", "example.com/post?a=1&b=2"},
+		{"relative", "/post", ""},
+		{"invalid", "://", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := URLKey(tt.link); got != tt.want {
+				t.Errorf("URLKey(%q) = %q, want %q", tt.link, got, tt.want)
+			}
+		})
+	}
+}
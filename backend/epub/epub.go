@@ -0,0 +1,345 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Book is an EPUB 3 publication with one chapter per article
+type Book struct {
+	Title    string
+	Language string
+	Chapters []Chapter
+}
+
+// Chapter is one article. Content is sanitized article HTML.
+type Chapter struct {
+	Title   string
+	Byline  string
+	Link    string
+	Content string
+}
+
+// ImageLoader fetches an image to embed, returning its content type
+type ImageLoader func(imageURL string) ([]byte, string, error)
+
+// maxImageBytes caps the images embedded in one book. Images past it are
+// left out, like images that fail to load.
+const maxImageBytes = 100 << 20
+
+// imageTypes are the image formats reading systems must support, with the
+// extension used for them
+var imageTypes = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+const containerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const stylesheet = `body { font-family: serif; line-height: 1.5; margin: 0 5%; }
+h1 { font-size: 1.5em; line-height: 1.2; margin-bottom: 0.3em; }
+p.byline, p.source { color: #555; font-size: 0.85em; margin: 0.2em 0; }
+p.source a { word-break: break-all; }
+img { max-width: 100%; height: auto; }
+pre { white-space: pre-wrap; }
+blockquote { margin-left: 1em; padding-left: 1em; border-left: 2px solid #ccc; }
+`
+
+// file is an entry of the EPUB container
+type file struct {
+	name string
+	data []byte
+}
+
+// image is an embedded image in the manifest
+type image struct {
+	id        string
+	href      string
+	mediaType string
+	data      []byte
+}
+
+type builder struct {
+	load      ImageLoader
+	images    []*image
+	byURL     map[string]*image
+	failed    map[string]bool
+	imageSize int
+}
+
+// Write writes book as an EPUB 3 file. Images in chapter content are
+// fetched with load and embedded; images that can't be are removed.
+func Write(w io.Writer, book Book, load ImageLoader) error {
+	if book.Language == "" {
+		book.Language = "en"
+	}
+	b := &builder{load: load, byURL: map[string]*image{}, failed: map[string]bool{}}
+
+	chapters := make([][]byte, len(book.Chapters))
+	for i, ch := range book.Chapters {
+		content, err := b.chapter(ch, book.Language)
+		if err != nil {
+			return fmt.Errorf("chapter %d: %w", i+1, err)
+		}
+		chapters[i] = content
+	}
+
+	z := zip.NewWriter(w)
+
+	// The mimetype must be the first entry, stored uncompressed
+	mimetype, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	uid := newUUID()
+	files := []file{
+		{"META-INF/container.xml", []byte(containerXML)},
+		{"OEBPS/content.opf", b.packageDocument(book, uid)},
+		{"OEBPS/nav.xhtml", navDocument(book)},
+		{"OEBPS/toc.ncx", ncxDocument(book, uid)},
+		{"OEBPS/style.css", []byte(stylesheet)},
+	}
+	for i, content := range chapters {
+		files = append(files, file{"OEBPS/" + chapterFile(i), content})
+	}
+	for _, img := range b.images {
+		files = append(files, file{"OEBPS/" + img.href, img.data})
+	}
+
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+func chapterFile(i int) string {
+	return fmt.Sprintf("chapter-%03d.xhtml", i+1)
+}
+
+// chapter renders an article as an XHTML content document
+func (b *builder) chapter(ch Chapter, lang string) ([]byte, error) {
+	body := &nethtml.Node{Type: nethtml.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := nethtml.ParseFragment(strings.NewReader(ch.Content), body)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="%[1]s" xml:lang="%[1]s">
+<head>
+<meta charset="utf-8"/>
+<title>%[2]s</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+<section epub:type="chapter">
+<h1>%[2]s</h1>
+`, html.EscapeString(lang), html.EscapeString(ch.Title))
+	if ch.Byline != "" {
+		fmt.Fprintf(&buf, "<p class=\"byline\">%s</p>\n", html.EscapeString(ch.Byline))
+	}
+	if ch.Link != "" {
+		fmt.Fprintf(&buf, "<p class=\"source\"><a href=\"%[1]s\">%[1]s</a></p>\n", html.EscapeString(ch.Link))
+	}
+
+	for _, n := range nodes {
+		b.embedImages(n)
+		// html.Render closes void elements and quotes and escapes
+		// attributes, which is all sanitized content needs to be XHTML
+		if err := nethtml.Render(&buf, n); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString("\n</section>\n</body>\n</html>\n")
+	return buf.Bytes(), nil
+}
+
+// embedImages points the images under n at embedded copies, removing the
+// ones that can't be embedded
+func (b *builder) embedImages(n *nethtml.Node) {
+	var next *nethtml.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+		if c.Type == nethtml.ElementNode && c.DataAtom == atom.Img {
+			if !b.embedImage(c) {
+				n.RemoveChild(c)
+			}
+			continue
+		}
+		b.embedImages(c)
+	}
+}
+
+// embedImage embeds the image an <img> refers to and reports whether it
+// could be
+func (b *builder) embedImage(img *nethtml.Node) bool {
+	var src string
+	attrs := img.Attr[:0]
+	for _, a := range img.Attr {
+		switch a.Key {
+		case "src":
+			src = a.Val
+		case "srcset", "sizes":
+		default:
+			attrs = append(attrs, a)
+		}
+	}
+	img.Attr = attrs
+	if src == "" || b.failed[src] {
+		return false
+	}
+
+	embedded, ok := b.byURL[src]
+	if !ok {
+		data, contentType, err := b.load(src)
+		contentType, _, _ = strings.Cut(contentType, ";")
+		ext, supported := imageTypes[strings.TrimSpace(contentType)]
+		if err != nil || !supported || b.imageSize+len(data) > maxImageBytes {
+			b.failed[src] = true
+			return false
+		}
+		b.imageSize += len(data)
+
+		n := len(b.images) + 1
+		embedded = &image{
+			id:        fmt.Sprintf("img-%03d", n),
+			href:      fmt.Sprintf("images/img-%03d%s", n, ext),
+			mediaType: strings.TrimSpace(contentType),
+			data:      data,
+		}
+		b.images = append(b.images, embedded)
+		b.byURL[src] = embedded
+	}
+
+	img.Attr = append(img.Attr, nethtml.Attribute{Key: "src", Val: embedded.href})
+	if !hasAttr(img, "alt") {
+		img.Attr = append(img.Attr, nethtml.Attribute{Key: "alt", Val: ""})
+	}
+	return true
+}
+
+func hasAttr(n *nethtml.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// packageDocument is the OPF file listing the book's metadata, files and
+// reading order
+func (b *builder) packageDocument(book Book, uid string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" xml:lang="%s">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="uid">urn:uuid:%s</dc:identifier>
+<dc:title>%s</dc:title>
+<dc:language>%s</dc:language>
+<dc:creator>go-reader</dc:creator>
+<meta property="dcterms:modified">%s</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+<item id="css" href="style.css" media-type="text/css"/>
+`, html.EscapeString(book.Language), uid, html.EscapeString(book.Title), html.EscapeString(book.Language),
+		time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+
+	for i := range book.Chapters {
+		fmt.Fprintf(&buf, "<item id=\"chapter-%03d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, chapterFile(i))
+	}
+	for _, img := range b.images {
+		fmt.Fprintf(&buf, "<item id=\"%s\" href=\"%s\" media-type=\"%s\"/>\n", img.id, img.href, img.mediaType)
+	}
+
+	buf.WriteString("</manifest>\n<spine toc=\"ncx\">\n<itemref idref=\"nav\"/>\n")
+	for i := range book.Chapters {
+		fmt.Fprintf(&buf, "<itemref idref=\"chapter-%03d\"/>\n", i+1)
+	}
+	buf.WriteString("</spine>\n</package>\n")
+	return buf.Bytes()
+}
+
+// navDocument is the EPUB 3 table of contents
+func navDocument(book Book) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="%[1]s" xml:lang="%[1]s">
+<head>
+<meta charset="utf-8"/>
+<title>%[2]s</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>%[2]s</h1>
+<ol>
+`, html.EscapeString(book.Language), html.EscapeString(book.Title))
+	for i, ch := range book.Chapters {
+		fmt.Fprintf(&buf, "<li><a href=\"%s\">%s</a></li>\n", chapterFile(i), html.EscapeString(ch.Title))
+	}
+	buf.WriteString("</ol>\n</nav>\n</body>\n</html>\n")
+	return buf.Bytes()
+}
+
+// ncxDocument is the EPUB 2 table of contents, for older reading systems
+func ncxDocument(book Book, uid string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<head>
+<meta name="dtb:uid" content="urn:uuid:%s"/>
+<meta name="dtb:depth" content="1"/>
+</head>
+<docTitle><text>%s</text></docTitle>
+<navMap>
+`, uid, html.EscapeString(book.Title))
+	for i, ch := range book.Chapters {
+		fmt.Fprintf(&buf, "<navPoint id=\"nav-%03d\" playOrder=\"%d\"><navLabel><text>%s</text></navLabel><content src=\"%s\"/></navPoint>\n",
+			i+1, i+1, html.EscapeString(ch.Title), chapterFile(i))
+	}
+	buf.WriteString("</navMap>\n</ncx>\n")
+	return buf.Bytes()
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
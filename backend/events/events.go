@@ -0,0 +1,212 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// Event types pushed to clients
+const (
+	ArticleCreated = "article.created"
+	ArticleUpdated = "article.updated"
+	ArticleRead    = "article.read"
+	ArticleStarred = "article.starred"
+	FeedFailed     = "feed.failed"
+	FeedRecovered  = "feed.recovered"
+
+	// reset tells a resuming client that events were dropped from the log
+	// and it should reload its state
+	reset = "reset"
+)
+
+// subscriberBuffer is how many events a slow client may fall behind before
+// it is disconnected. It will resume from the log when it reconnects.
+const subscriberBuffer = 64
+
+// trimEvery is how many events are published between trims of the event
+// log, which may briefly hold that many more than db.EventLogSize
+const trimEvery = 100
+
+var (
+	mu          sync.Mutex
+	subscribers = map[chan db.Event]struct{}{}
+	published   atomic.Int64
+)
+
+// Publish records an event in the event log and pushes it to all connected
+// clients. Failures are logged; publishing never blocks the caller on slow
+// clients. Events published concurrently may reach clients out of order;
+// their IDs give the order they were logged in.
+func Publish(ctx context.Context, eventType string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding event", "type", eventType, "error", err)
+		return
+	}
+
+	// The change has already been made, so it's logged even if the caller
+	// has gone away
+	logCtx := context.WithoutCancel(ctx)
+	event, err := db.CreateEvent(logCtx, eventType, payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error logging event", "type", eventType, "error", err)
+		// Still deliver it live, just without an ID to resume from
+		event = &db.Event{Type: eventType, Data: payload, CreatedAt: time.Now()}
+	}
+	if published.Add(1)%trimEvery == 0 {
+		if err := db.TrimEvents(logCtx); err != nil {
+			slog.ErrorContext(ctx, "Error trimming event log", "error", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- *event:
+		default:
+			// Client can't keep up; drop it and let it resume from the log
+			delete(subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// CloseAll disconnects every client, so the server can shut down. Clients
+// reconnect and resume from the event log.
+func CloseAll() {
+	mu.Lock()
+	for ch := range subscribers {
+		delete(subscribers, ch)
+		close(ch)
+	}
+	mu.Unlock()
+}
+
+func subscribe() chan db.Event {
+	ch := make(chan db.Event, subscriberBuffer)
+	mu.Lock()
+	subscribers[ch] = struct{}{}
+	mu.Unlock()
+	return ch
+}
+
+func unsubscribe(ch chan db.Event) {
+	mu.Lock()
+	if _, ok := subscribers[ch]; ok {
+		delete(subscribers, ch)
+		close(ch)
+	}
+	mu.Unlock()
+}
+
+// ServeEvents streams events to the client as Server-Sent Events. Clients
+// that send Last-Event-ID (or ?lastEventId=) get the events they missed
+// replayed from the event log first.
+func ServeEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventParam := r.Header.Get("Last-Event-ID")
+	if lastEventParam == "" {
+		lastEventParam = r.URL.Query().Get("lastEventId")
+	}
+	var lastEventID int64
+	if lastEventParam != "" {
+		id, err := strconv.ParseInt(lastEventParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastEventID = id
+	}
+
+	// Subscribe before replaying so nothing published in between is lost
+	ch := subscribe()
+	defer unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+
+	// IDs replayed from the log, which may also arrive live
+	replayed := map[int64]bool{}
+	if lastEventParam != "" {
+		if err := replay(r.Context(), w, lastEventID, replayed); err != nil {
+			slog.ErrorContext(r.Context(), "Error replaying events", "lastEventId", lastEventID, "error", err)
+			writeEvent(w, db.Event{Type: reset, Data: json.RawMessage(`{}`)})
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				// Dropped for falling behind
+				return
+			}
+			// Skip anything already sent during replay. Comparing IDs
+			// instead would drop events logged before the newest replayed
+			// one but not yet published.
+			if replayed[event.ID] {
+				continue
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// replay writes the logged events after lastEventID, adding their IDs to
+// sent
+func replay(ctx context.Context, w http.ResponseWriter, lastEventID int64, sent map[int64]bool) error {
+	oldest, err := db.GetOldestEventID(ctx)
+	if err != nil {
+		return err
+	}
+	if oldest > lastEventID+1 {
+		// Some of the events the client missed are no longer in the log
+		writeEvent(w, db.Event{Type: reset, Data: json.RawMessage(`{}`)})
+	}
+
+	missed, err := db.GetEventsAfter(ctx, lastEventID)
+	if err != nil {
+		return err
+	}
+	for _, event := range missed {
+		writeEvent(w, event)
+		sent[event.ID] = true
+	}
+	return nil
+}
+
+func writeEvent(w http.ResponseWriter, event db.Event) {
+	if event.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", event.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+}
@@ -0,0 +1,146 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/rss"
+)
+
+// pingTimeout bounds the database check of /readyz, so a hung database
+// fails the probe instead of hanging it
+const pingTimeout = 2 * time.Second
+
+var (
+	version   = "dev"
+	startedAt = time.Now()
+)
+
+// SetVersion sets the version reported by /api/status
+func SetVersion(v string) {
+	if v != "" {
+		version = v
+	}
+}
+
+// Build identifies the running binary
+type Build struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"` // VCS commit, when built from a checkout
+	Modified  bool   `json:"modified,omitempty"` // Built with uncommitted changes
+	GoVersion string `json:"goVersion"`
+}
+
+// Status is the detailed report served by /api/status
+type Status struct {
+	Build     Build              `json:"build"`
+	StartedAt time.Time          `json:"startedAt"`
+	Uptime    string             `json:"uptime"`
+	Ready     bool               `json:"ready"`
+	Checks    map[string]string  `json:"checks"` // Readiness checks, plus "fetcher"
+	Database  db.PoolStats       `json:"database"`
+	Fetcher   *rss.FetcherStatus `json:"fetcher"`
+}
+
+func build() Build {
+	b := Build{Version: version, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				b.Revision = setting.Value
+			case "vcs.modified":
+				b.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return b
+}
+
+// check runs the readiness checks and returns each one's result, "ok" or
+// the problem, and whether all passed
+func check(ctx context.Context) (map[string]string, bool) {
+	results := map[string]string{}
+	ready := true
+	record := func(name string, err error) {
+		if err != nil {
+			results[name] = err.Error()
+			ready = false
+			return
+		}
+		results[name] = "ok"
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	record("database", db.Ping(pingCtx))
+
+	record("migrations", db.CheckSchema(pingCtx))
+	return results, ready
+}
+
+// Healthz reports that the process is alive and serving requests. It checks
+// nothing else, so a struggling database doesn't get the process restarted.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether the server can handle requests: the database is
+// reachable and the schema is up to date. It responds 503 with the failing
+// checks otherwise. The API keeps serving while the fetcher is slow, so a
+// stalled fetcher is only reported by /api/status.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	checks, ready := check(r.Context())
+
+	status := http.StatusOK
+	body := map[string]any{"status": "ok", "checks": checks}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		body["status"] = "unavailable"
+		slog.WarnContext(r.Context(), "Readiness check failed", "checks", checks)
+	}
+	writeJSON(w, status, body)
+}
+
+// GetStatus reports the build, readiness checks, connection pool and the
+// state of the background fetcher. Checks include whether the fetcher is
+// stalled, which doesn't affect Ready.
+func GetStatus(w http.ResponseWriter, r *http.Request) {
+	fetcher, err := rss.GetFetcherStatus(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting fetcher status", "error", err)
+		http.Error(w, "Failed to get fetcher status", http.StatusInternalServerError)
+		return
+	}
+
+	checks, ready := check(r.Context())
+	checks["fetcher"] = "ok"
+	if err := rss.FetcherStalled(); err != nil {
+		checks["fetcher"] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, Status{
+		Build:     build(),
+		StartedAt: startedAt,
+		Uptime:    time.Since(startedAt).Round(time.Second).String(),
+		Ready:     ready,
+		Checks:    checks,
+		Database:  db.GetPoolStats(),
+		Fetcher:   fetcher,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding response", "error", err)
+	}
+}
@@ -0,0 +1,190 @@
+package images
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps images on disk, evicting the least recently used ones once
+// the files add up to more than the size limit. Each file holds the content
+// type on the first line followed by the image data. Recency survives
+// restarts through the files' modification times.
+//
+// A nil *Cache caches nothing.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // Most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+// OpenCache opens (or creates) a cache directory and indexes the files
+// already in it
+func OpenCache(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type stored struct {
+		entry   cacheEntry
+		modTime time.Time
+	}
+	var existing []stored
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		// Left over from a write interrupted by a crash
+		if strings.HasPrefix(f.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, stored{cacheEntry{f.Name(), info.Size()}, info.ModTime()})
+	}
+	slices.SortFunc(existing, func(a, b stored) int { return b.modTime.Compare(a.modTime) })
+
+	c := &Cache{dir: dir, maxBytes: maxBytes, order: list.New(), entries: map[string]*list.Element{}}
+	for _, s := range existing {
+		c.entries[s.entry.name] = c.order.PushBack(&s.entry)
+		c.size += s.entry.size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// fileName maps a cache key to the name of its file
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns a cached image and its content type
+func (c *Cache) Get(key string) (data []byte, contentType string, ok bool) {
+	if c == nil {
+		return nil, "", false
+	}
+	name := fileName(key)
+
+	c.mu.Lock()
+	el, ok := c.entries[name]
+	if ok {
+		c.order.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, "", false
+	}
+
+	path := filepath.Join(c.dir, name)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		c.remove(name)
+		return nil, "", false
+	}
+	header, data, found := bytes.Cut(raw, []byte("\n"))
+	if !found {
+		c.remove(name)
+		return nil, "", false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, string(header), true
+}
+
+// Put stores an image, evicting older ones to stay under the size limit.
+// Images larger than the whole cache aren't stored.
+func (c *Cache) Put(key, contentType string, data []byte) {
+	if c == nil {
+		return
+	}
+	size := int64(len(contentType) + 1 + len(data))
+	if size > c.maxBytes {
+		return
+	}
+
+	tmp, err := os.CreateTemp(c.dir, ".tmp")
+	if err != nil {
+		slog.Error("Error writing image cache", "error", err)
+		return
+	}
+	_, err = tmp.Write(append([]byte(contentType+"\n"), data...))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	name := fileName(key)
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		slog.Error("Error writing image cache", "error", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[name]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.order.Remove(el)
+	}
+	c.entries[name] = c.order.PushFront(&cacheEntry{name, size})
+	c.size += size
+	c.evict()
+}
+
+// remove drops an entry whose file is missing or unreadable
+func (c *Cache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[name]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.order.Remove(el)
+		delete(c.entries, name)
+	}
+	os.Remove(filepath.Join(c.dir, name))
+}
+
+// evict deletes least recently used files until the cache fits. c.mu must
+// be held.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes && c.order.Len() > 0 {
+		el := c.order.Back()
+		entry := el.Value.(*cacheEntry)
+		if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+			slog.Error("Error evicting cached image", "file", entry.name, "error", err)
+		}
+		c.order.Remove(el)
+		delete(c.entries, entry.name)
+		c.size -= entry.size
+	}
+}
@@ -0,0 +1,199 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"time"
+
+	// Decoders for the formats thumbnails are made from
+	_ "image/gif"
+	_ "image/png"
+)
+
+// maxImageBytes is the largest image that is downloaded
+const maxImageBytes = 15 << 20
+
+// maxPixels is the largest image that is decoded. A small file can still
+// decode to a huge bitmap: 12 megapixels take up to 48 MB decoded.
+const maxPixels = 12_000_000
+
+// thumbnailSlots bounds how many images are decoded and scaled at once, so
+// concurrent thumbnail requests can't exhaust memory
+var thumbnailSlots = make(chan struct{}, 2)
+
+// headerBytes is how much of an image is read to find its dimensions
+const headerBytes = 256 << 10
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// Size returns the dimensions of a remote image, reading only as much of it
+// as needed to decode the header. Formats without a registered decoder
+// (WebP, SVG) are an error.
+func Size(ctx context.Context, imageURL string) (width, height int, err error) {
+	response, err := get(ctx, imageURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer response.Body.Close()
+
+	cfg, _, err := image.DecodeConfig(io.LimitReader(response.Body, headerBytes))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// Fetch downloads and decodes a remote image
+func Fetch(ctx context.Context, imageURL string) (image.Image, error) {
+	data, _, err := download(ctx, imageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("image is too large (%dx%d)", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// download fetches a remote image up to maxImageBytes, returning its data
+// and the content type the server sent
+func download(ctx context.Context, imageURL string) ([]byte, string, error) {
+	response, err := get(ctx, imageURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxImageBytes {
+		return nil, "", fmt.Errorf("image is larger than %d bytes", maxImageBytes)
+	}
+	return data, response.Header.Get("Content-Type"), nil
+}
+
+// get fetches a URL, giving up when ctx is done
+func get(ctx context.Context, imageURL string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", imageURL, response.Status)
+	}
+	return response, nil
+}
+
+// ThumbnailJPEG returns a remote image scaled down to width as a JPEG,
+// from the cache when it was made before
+func ThumbnailJPEG(ctx context.Context, imageURL string, width int) ([]byte, error) {
+	key := fmt.Sprintf("thumbnail:%d:%s", width, imageURL)
+	if data, _, ok := cache.Get(key); ok {
+		return data, nil
+	}
+
+	select {
+	case thumbnailSlots <- struct{}{}:
+		defer func() { <-thumbnailSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	img, err := Fetch(ctx, imageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeJPEG(&buf, Thumbnail(img, width)); err != nil {
+		return nil, err
+	}
+	cache.Put(key, "image/jpeg", buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// Thumbnail scales an image down to the given width, keeping its aspect
+// ratio. Each thumbnail pixel is the average of the source pixels it covers,
+// and transparency is flattened onto white since thumbnails are JPEGs.
+// Images narrower than width are not enlarged. The source is flattened one
+// band of rows at a time rather than copied whole.
+func Thumbnail(src image.Image, width int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 {
+		return image.NewRGBA(image.Rect(0, 0, 1, 1))
+	}
+	if width > srcW {
+		width = srcW
+	}
+	height := max(1, srcH*width/srcW)
+
+	// The source rows covered by one thumbnail row
+	band := image.NewRGBA(image.Rect(0, 0, srcW, (srcH+height-1)/height+1))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		y0, y1 := span(y, height, srcH)
+		rows := image.Rect(0, 0, srcW, y1-y0)
+		draw.Draw(band, rows, image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(band, rows, src, image.Pt(bounds.Min.X, bounds.Min.Y+y0), draw.Over)
+
+		for x := range width {
+			x0, x1 := span(x, width, srcW)
+
+			var r, g, b, n int
+			for sy := range y1 - y0 {
+				row := band.Pix[sy*band.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
+
+// span returns the source pixel range [from, to) covered by destination
+// pixel i when scaling size src down to size dst
+func span(i, dst, src int) (from, to int) {
+	from = i * src / dst
+	to = (i + 1) * src / dst
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+// EncodeJPEG writes an image as a JPEG at thumbnail quality
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 80})
+}
@@ -10,25 +10,54 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/JonSchaeffer/go-reader/archive"
 	"github.com/JonSchaeffer/go-reader/config"
 	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/events"
+	"github.com/JonSchaeffer/go-reader/health"
+	"github.com/JonSchaeffer/go-reader/images"
+	"github.com/JonSchaeffer/go-reader/logging"
+	"github.com/JonSchaeffer/go-reader/metrics"
+	"github.com/JonSchaeffer/go-reader/openapi"
 	"github.com/JonSchaeffer/go-reader/rss"
+	"github.com/JonSchaeffer/go-reader/webhooks"
 )
 
-// CORS middleware to allow frontend access
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// Origins allowed by corsMiddleware, from the configuration
+var corsOrigins []string
+
+// corsMiddleware allows frontend access to the API. It wraps request
+// validation so the frontend can also read why a request was rejected.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if origin := allowedOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+logging.RequestIDHeader)
+		w.Header().Set("Access-Control-Expose-Headers", logging.RequestIDHeader)
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -37,68 +66,406 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		// Call the next handler
-		next(w, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, or "" when the origin isn't allowed
+func allowedOrigin(origin string) string {
+	for _, allowed := range corsOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
 	}
+	return ""
 }
 
 func main() {
 	// Load configuration
-	cfg := config.Load()
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Print(usage)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := logging.Setup(os.Stderr, cfg.Logging.Level, cfg.Logging.Format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	// Initialize database
-	err := db.Init(cfg.DatabaseURL)
+	// Without a command the server is started
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	if err := runCommand(cfg, args); err != nil {
+		slog.Error("Command failed", "command", args[0], "error", err)
+		os.Exit(1)
+	}
+}
+
+// shutdownTimeout is how long in-flight requests and workers get to finish
+// after a shutdown signal
+const shutdownTimeout = 30 * time.Second
+
+// serve runs the HTTP server and the background workers until ctx is
+// cancelled, then shuts them down gracefully
+func serve(ctx context.Context, cfg *config.Config) error {
+	err := setup(ctx, cfg)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer db.Close()
 
-	err = db.CreateRSSTable()
-	if err != nil {
-		log.Fatal(err)
+	if err := rss.SweepArchives(ctx); err != nil {
+		slog.ErrorContext(ctx, "Error cleaning up archived snapshots", "error", err)
 	}
 
-	err = db.CreateArticleTable()
+	err = openapi.Init()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	corsOrigins = cfg.Server.CORSOrigins
+
+	health.SetVersion(version)
+	registerRoutes(http.DefaultServeMux)
+
+	// Start the background workers. They stop when workerCtx is cancelled,
+	// by a shutdown signal or the server failing to start.
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+	var workers sync.WaitGroup
+	for _, worker := range []func(context.Context){rss.StartRSSFetcher, webhooks.StartDeliveryWorker, rss.StartArchiver, rss.StartLeadImageFinder} {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker(workerCtx)
+		}()
+	}
+
+	server := &http.Server{
+		Addr:    ":" + cfg.Server.Port,
+		Handler: logging.RequestID(metrics.Instrument(corsMiddleware(openapi.ValidateRequests(http.DefaultServeMux)))),
 	}
+	// Event streams never finish on their own
+	server.RegisterOnShutdown(events.CloseAll)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.InfoContext(ctx, "Server starting", "port", cfg.Server.Port)
+		serverErr <- server.ListenAndServe()
+	}()
 
-	// Set up HTTP routes with CORS middleware
-	http.HandleFunc("/api/rss", corsMiddleware(routeRss))
-	http.HandleFunc("/api/rss/stats", corsMiddleware(routeRSSStats))             // RSS feed statistics
-	http.HandleFunc("/api/categories", corsMiddleware(routeCategories))          // Category management
-	http.HandleFunc("/api/articles", corsMiddleware(routeAllArticles))           // All articles
-	http.HandleFunc("/api/articles/single", corsMiddleware(routeSingleArticle))  // Single article by ?id=
-	http.HandleFunc("/api/articles/by-rss", corsMiddleware(routeArticlesByRSS))  // Articles by RSS ID
-	http.HandleFunc("/api/articles/update", corsMiddleware(routeUpdateArticle))  // Update article read status
-	http.HandleFunc("/api/articles/search", corsMiddleware(routeSearchArticles)) // Search articles
-	http.HandleFunc("/api/articles/delete", corsMiddleware(routeDeleteArticle))  // Delete article by ?id=
+	select {
+	case err = <-serverErr:
+		// Couldn't listen; stop the workers before returning the error
+	case <-ctx.Done():
+		slog.InfoContext(ctx, "Shutting down")
+	}
 
-	// Start RSS fetcher in background
-	ctx, cancel := context.WithCancel(context.Background())
+	stopWorkers()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
-	// go rss.startRSSFetcher(ctx)
-	go rss.StartRSSFetcher(ctx)
 
-	// Handle shutdown signals in background
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Stop accepting connections and wait for in-flight requests. Requests
+	// still running at the deadline are cut off, which cancels their queries.
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		slog.ErrorContext(ctx, "Error shutting down HTTP server", "error", shutdownErr)
+		server.Close()
+	}
+
+	drained := make(chan struct{})
 	go func() {
-		<-sigChan
-		log.Println("Shutting down...")
-		cancel()
-		os.Exit(0)
+		workers.Wait()
+		close(drained)
 	}()
+	select {
+	case <-drained:
+		slog.InfoContext(ctx, "Shutdown complete")
+	case <-shutdownCtx.Done():
+		slog.WarnContext(ctx, "Timed out waiting for background workers to stop")
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// setup prepares everything the server and the commands that work on feeds
+// need: the database schema, the image proxy, the archive store and the rss
+// package's configuration
+func setup(ctx context.Context, cfg *config.Config) error {
+	err := initDatabase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	err = initImages(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	err = archive.Init(cfg.Storage.ArchiveDir)
+	if err != nil {
+		return err
+	}
 
 	// Set config for RSS package
 	rss.SetConfig(&rss.Config{
-		FiveFiltersURL: cfg.FiveFiltersURL,
+		FiveFiltersURL:      cfg.Fetcher.FiveFiltersURL,
+		FiveFiltersMaxItems: cfg.Fetcher.FiveFiltersMaxItems,
+		FetchInterval:       cfg.Fetcher.Interval,
+		FeedToken:           cfg.Server.FeedToken,
+		KeepRevisions:       cfg.Fetcher.KeepRevisions,
+		ExtraElements:       cfg.Sanitizer.ExtraElements,
+		ExtraAttributes:     cfg.Sanitizer.ExtraAttributes,
+		FetchHistoryDays:    cfg.Fetcher.HistoryDays,
 	})
+	return nil
+}
+
+// router is where routes are registered: http.DefaultServeMux when
+// serving, and a recorder in the test that checks the OpenAPI spec covers
+// every route
+type router interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+	Handle(pattern string, handler http.Handler)
+}
+
+// registerRoutes sets up every HTTP route
+func registerRoutes(mux router) {
+	// Set up HTTP routes; corsMiddleware is applied to all of them in main
+	mux.HandleFunc("/api/rss", routeRss)
+	mux.HandleFunc("/api/rss/stats", routeRSSStats)                 // RSS feed statistics
+	mux.HandleFunc("GET /api/rss/{id}/fetches", rss.GetFeedFetches) // Fetch history of a feed
+	mux.HandleFunc("/api/categories", routeCategories)              // Category management
+	mux.HandleFunc("/api/articles", routeAllArticles)               // All articles
+	mux.HandleFunc("/api/articles/single", routeSingleArticle)      // Single article by ?id=
+	mux.HandleFunc("/api/articles/by-rss", routeArticlesByRSS)      // Articles by RSS ID
+	mux.HandleFunc("/api/articles/update", routeUpdateArticle)      // Update article read status
+	mux.HandleFunc("/api/articles/search", routeSearchArticles)     // Search articles
+	mux.HandleFunc("/api/articles/delete", routeDeleteArticle)      // Delete article by ?id=
+	mux.HandleFunc("/api/openapi.json", openapi.ServeSpec)          // OpenAPI document
+	mux.HandleFunc("GET /api/events", events.ServeEvents)           // Server-Sent Events stream
+	mux.HandleFunc("GET /api/status", health.GetStatus)             // Build, database and fetcher status
+
+	// Probes
+	mux.HandleFunc("GET /healthz", health.Healthz) // Liveness
+	mux.HandleFunc("GET /readyz", health.Readyz)   // Readiness
+
+	// Prometheus scrape endpoint
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	registerV2Routes(mux)
+
+	// Republished RSS/Atom feeds
+	mux.HandleFunc("GET /api/feeds/all.xml", rss.PublishAllRSS)
+	mux.HandleFunc("GET /api/feeds/all.atom", rss.PublishAllAtom)
+	mux.HandleFunc("GET /api/feeds/starred.xml", rss.PublishStarredRSS)
+	mux.HandleFunc("GET /api/feeds/starred.atom", rss.PublishStarredAtom)
+	mux.HandleFunc("GET /api/feeds/search.xml", rss.PublishSearchRSS)
+	mux.HandleFunc("GET /api/feeds/search.atom", rss.PublishSearchAtom)
+	mux.HandleFunc("GET /api/feeds/category/{file}", rss.PublishCategory) // {id}.xml or {id}.atom
+	mux.HandleFunc("GET /api/feeds/source/{file}", rss.PublishSource)     // {id}.xml or {id}.atom
+}
+
+// registerV2Routes sets up the v2 API using method+path patterns. The v1
+// routes above stay in place for the existing frontend.
+func registerV2Routes(mux router) {
+	// Feeds
+	mux.HandleFunc("GET /api/v2/feeds", rss.ListFeedsV2)
+	mux.HandleFunc("POST /api/v2/feeds", rss.CreateFeedV2)
+	mux.HandleFunc("GET /api/v2/feeds/{id}", rss.GetFeedV2)
+	mux.HandleFunc("PATCH /api/v2/feeds/{id}", rss.UpdateFeedV2)
+	mux.HandleFunc("DELETE /api/v2/feeds/{id}", rss.DeleteFeedV2)
+	mux.HandleFunc("GET /api/v2/feeds/{id}/stats", rss.GetFeedStatsV2)
+	mux.HandleFunc("GET /api/v2/feeds/{id}/articles", rss.ListFeedArticlesV2)
+	mux.HandleFunc("GET /api/v2/feeds/{id}/url-rules", rss.GetFeedURLRulesV2)
+	mux.HandleFunc("PUT /api/v2/feeds/{id}/url-rules", rss.UpdateFeedURLRulesV2)
+
+	// Articles
+	mux.HandleFunc("GET /api/v2/articles", rss.ListArticlesV2)
+	mux.HandleFunc("GET /api/v2/articles/search", rss.SearchArticlesV2)
+	mux.HandleFunc("GET /api/v2/articles/{id}", rss.GetArticleV2)
+	mux.HandleFunc("PATCH /api/v2/articles/{id}", rss.UpdateArticleV2)
+	mux.HandleFunc("DELETE /api/v2/articles/{id}", rss.DeleteArticleV2)
+	mux.HandleFunc("GET /api/v2/articles/{id}/revisions", rss.ListArticleRevisionsV2)
+	mux.HandleFunc("GET /api/v2/articles/{id}/thumbnail", rss.GetArticleThumbnail)
+	mux.HandleFunc("GET /api/v2/articles/{id}/archive", rss.GetArticleArchive)
+	mux.HandleFunc("POST /api/v2/articles/{id}/archive", rss.ArchiveArticle)
+	mux.HandleFunc("DELETE /api/v2/articles/{id}/archive", rss.DeleteArticleArchive)
+	mux.HandleFunc("POST /api/v2/articles/{id}/tags", rss.AddArticleTag)
+	mux.HandleFunc("DELETE /api/v2/articles/{id}/tags/{tagId}", rss.RemoveArticleTag)
+
+	// EPUB export
+	mux.HandleFunc("GET /api/v2/epub", rss.ExportEPUB)
+
+	// Backup and restore
+	mux.HandleFunc("GET /api/v2/backup", rss.ExportBackup)
+	mux.HandleFunc("POST /api/v2/backup", rss.ImportBackup)
 
-	// Start HTTP server (this blocks)
-	fmt.Printf("Server starting on :%s\n", cfg.Port)
-	if err := http.ListenAndServe(":"+cfg.Port, nil); err != nil {
-		log.Printf("Server failed to start: %v", err)
+	// Image proxy
+	mux.HandleFunc("GET /api/v2/images", images.ServeProxy)
+
+	// Enclosures
+	mux.HandleFunc("GET /api/v2/enclosures/{id}", rss.GetEnclosure)
+	mux.HandleFunc("PUT /api/v2/enclosures/{id}/position", rss.UpdatePlaybackPosition)
+
+	// Annotations
+	mux.HandleFunc("GET /api/v2/articles/{id}/annotations", rss.ListArticleAnnotations)
+	mux.HandleFunc("POST /api/v2/articles/{id}/annotations", rss.CreateAnnotation)
+	mux.HandleFunc("GET /api/v2/articles/{id}/annotations/export", rss.ExportArticleAnnotations)
+	mux.HandleFunc("GET /api/v2/annotations/export", rss.ExportAnnotations)
+	mux.HandleFunc("GET /api/v2/annotations/{id}", rss.GetAnnotation)
+	mux.HandleFunc("PATCH /api/v2/annotations/{id}", rss.UpdateAnnotation)
+	mux.HandleFunc("DELETE /api/v2/annotations/{id}", rss.DeleteAnnotation)
+
+	// Tags
+	mux.HandleFunc("GET /api/v2/tags", rss.ListTags)
+	mux.HandleFunc("GET /api/v2/tags/{id}", rss.GetTag)
+	mux.HandleFunc("DELETE /api/v2/tags/{id}", rss.DeleteTag)
+	mux.HandleFunc("GET /api/v2/tags/{id}/articles", rss.ListTagArticles)
+
+	// Categories
+	mux.HandleFunc("GET /api/v2/categories", rss.ListCategoriesV2)
+	mux.HandleFunc("POST /api/v2/categories", rss.CreateCategoryV2)
+	mux.HandleFunc("GET /api/v2/categories/{id}", rss.GetCategoryV2)
+	mux.HandleFunc("PATCH /api/v2/categories/{id}", rss.UpdateCategoryV2)
+	mux.HandleFunc("DELETE /api/v2/categories/{id}", rss.DeleteCategoryV2)
+	mux.HandleFunc("GET /api/v2/categories/{id}/feeds", rss.ListCategoryFeedsV2)
+
+	// Webhooks
+	mux.HandleFunc("GET /api/v2/webhooks", rss.ListWebhooks)
+	mux.HandleFunc("POST /api/v2/webhooks", rss.CreateWebhook)
+	mux.HandleFunc("GET /api/v2/webhooks/{id}", rss.GetWebhook)
+	mux.HandleFunc("PATCH /api/v2/webhooks/{id}", rss.UpdateWebhook)
+	mux.HandleFunc("DELETE /api/v2/webhooks/{id}", rss.DeleteWebhook)
+	mux.HandleFunc("GET /api/v2/webhooks/{id}/deliveries", rss.ListWebhookDeliveries)
+	mux.HandleFunc("GET /api/v2/webhook-deliveries/{id}", rss.GetWebhookDelivery)
+	mux.HandleFunc("POST /api/v2/webhook-deliveries/{id}/replay", rss.ReplayWebhookDelivery)
+
+	// Filter rules
+	mux.HandleFunc("GET /api/v2/rules", rss.ListRules)
+	mux.HandleFunc("POST /api/v2/rules", rss.CreateRule)
+	mux.HandleFunc("POST /api/v2/rules/test", rss.TestRule)
+	mux.HandleFunc("GET /api/v2/rules/{id}", rss.GetRule)
+	mux.HandleFunc("PATCH /api/v2/rules/{id}", rss.UpdateRule)
+	mux.HandleFunc("DELETE /api/v2/rules/{id}", rss.DeleteRule)
+	mux.HandleFunc("GET /api/v2/rules/{id}/test", rss.TestSavedRule)
+}
+
+// initDatabase connects to the database and creates or migrates all tables
+func initDatabase(ctx context.Context, cfg *config.Config) error {
+	err := db.Init(ctx, cfg.Database.URL, db.PoolConfig{
+		MaxConns:          int32(cfg.Database.MaxConns),
+		MinConns:          int32(cfg.Database.MinConns),
+		MaxConnLifetime:   cfg.Database.MaxConnLifetime,
+		MaxConnIdleTime:   cfg.Database.MaxConnIdleTime,
+		HealthCheckPeriod: cfg.Database.HealthCheckPeriod,
+	})
+	if err != nil {
+		return err
 	}
+
+	err = db.CreateRSSTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateArticleTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateWebhookTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateRuleTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateEnclosureTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateArticleRevisionTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateTagTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateAnnotationTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateArchiveTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateEventTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateSettingTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = db.CreateFetchLogTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = rss.BackfillURLKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	return db.MarkMigrated(ctx)
+}
+
+// initImages sets up the image proxy and cache. Clients and republished
+// feeds keep proxy URLs, so without a configured key one is generated once
+// and kept in the database.
+func initImages(ctx context.Context, cfg *config.Config) error {
+	proxyKey := cfg.Images.ProxyKey
+	if proxyKey == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		stored, err := db.GetOrCreateSetting(ctx, "image_proxy_key", hex.EncodeToString(random))
+		if err != nil {
+			return err
+		}
+		proxyKey = stored
+	}
+
+	return images.Init(images.Config{
+		Proxy:     cfg.Images.Proxy,
+		PublicURL: cfg.Server.PublicURL,
+		Key:       []byte(proxyKey),
+		CacheDir:  cfg.Storage.CacheDir,
+		CacheSize: int64(cfg.Images.CacheMB) << 20,
+	})
 }
 
 func routeRss(w http.ResponseWriter, r *http.Request) {
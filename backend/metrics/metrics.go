@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of a feed fetch
+const (
+	FetchSuccess    = "success"
+	FetchError      = "fetch_error" // The request failed or the response couldn't be read
+	FetchParseError = "parse_error" // The response wasn't a feed
+	FetchCancelled  = "cancelled"   // Interrupted by shutdown
+)
+
+// What happened to a feed item during a fetch
+const (
+	ItemInserted  = "inserted"
+	ItemUpdated   = "updated"   // Stored article edited by the publisher
+	ItemUnchanged = "unchanged" // Already stored with the same content
+	ItemSkipped   = "skipped"   // Dropped by a feed rule
+	ItemDuplicate = "duplicate" // Same link as a stored article with a different GUID
+	ItemFailed    = "failed"
+)
+
+// Feed labels are feed IDs, which stay stable when a feed is renamed
+var (
+	feedFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goreader_feed_fetch_duration_seconds",
+		Help:    "Time to fetch a feed and store its items, by feed and outcome.",
+		Buckets: []float64{0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"feed", "outcome"})
+
+	feedResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goreader_feed_fetch_responses_total",
+		Help: "HTTP responses to feed fetches, by feed and status code.",
+	}, []string{"feed", "code"})
+
+	feedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goreader_feed_downloaded_bytes_total",
+		Help: "Bytes of feed responses downloaded, by feed.",
+	}, []string{"feed"})
+
+	feedItems = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goreader_feed_items_total",
+		Help: "Feed items handled by fetches, by feed and result.",
+	}, []string{"feed", "result"})
+
+	fetchCycleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "goreader_fetch_cycle_duration_seconds",
+		Help:    "Time to fetch all feeds.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200},
+	})
+
+	contentProcessing = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "goreader_content_processing_seconds",
+		Help:    "Time the content processor takes to clean one article.",
+		Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1},
+	})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goreader_http_request_duration_seconds",
+		Help:    "Time to serve API requests, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goreader_http_requests_total",
+		Help: "API requests served, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(poolCollector{})
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// FeedFetched records a fetch of a feed
+func FeedFetched(feedID int, outcome string, duration time.Duration) {
+	feedFetchDuration.WithLabelValues(strconv.Itoa(feedID), outcome).Observe(duration.Seconds())
+}
+
+// FeedResponse records the status code and size of a feed's response
+func FeedResponse(feedID int, status int, bytes int) {
+	feed := strconv.Itoa(feedID)
+	feedResponses.WithLabelValues(feed, strconv.Itoa(status)).Inc()
+	feedBytes.WithLabelValues(feed).Add(float64(bytes))
+}
+
+// FeedItem records what a fetch did with one of a feed's items
+func FeedItem(feedID int, result string) {
+	feedItems.WithLabelValues(strconv.Itoa(feedID), result).Inc()
+}
+
+// FetchCycle records a fetch of all feeds
+func FetchCycle(duration time.Duration) {
+	fetchCycleDuration.Observe(duration.Seconds())
+}
+
+// ContentProcessed records the time spent processing an article's content
+func ContentProcessed(duration time.Duration) {
+	contentProcessing.Observe(duration.Seconds())
+}
+
+// Instrument records the latency and status code of each request next
+// serves. Requests are labelled by the ServeMux pattern that matched them,
+// so next must be, or pass the request unchanged to, the ServeMux.
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// Unmatched paths are grouped, so scanners can't create new series
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		requests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+	})
+}
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps event streams working through the recorder
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
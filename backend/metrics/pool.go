@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reports the database connection pool's statistics, read
+// from db.DB.Stat() on each scrape
+type poolCollector struct{}
+
+var (
+	poolMaxConns = prometheus.NewDesc("goreader_db_pool_max_conns",
+		"Maximum size of the connection pool.", nil, nil)
+	poolTotalConns = prometheus.NewDesc("goreader_db_pool_total_conns",
+		"Connections currently in the pool.", nil, nil)
+	poolIdleConns = prometheus.NewDesc("goreader_db_pool_idle_conns",
+		"Idle connections in the pool.", nil, nil)
+	poolAcquiredConns = prometheus.NewDesc("goreader_db_pool_acquired_conns",
+		"Connections currently in use.", nil, nil)
+	poolConstructingConns = prometheus.NewDesc("goreader_db_pool_constructing_conns",
+		"Connections being opened.", nil, nil)
+	poolAcquires = prometheus.NewDesc("goreader_db_pool_acquires_total",
+		"Connections acquired from the pool.", nil, nil)
+	poolEmptyAcquires = prometheus.NewDesc("goreader_db_pool_empty_acquires_total",
+		"Acquires that had to wait for a connection because none was idle.", nil, nil)
+	poolCanceledAcquires = prometheus.NewDesc("goreader_db_pool_canceled_acquires_total",
+		"Acquires cancelled before a connection was available.", nil, nil)
+	poolAcquireSeconds = prometheus.NewDesc("goreader_db_pool_acquire_seconds_total",
+		"Total time spent waiting for connections.", nil, nil)
+	poolNewConns = prometheus.NewDesc("goreader_db_pool_new_conns_total",
+		"Connections opened.", nil, nil)
+	poolLifetimeDestroys = prometheus.NewDesc("goreader_db_pool_max_lifetime_destroys_total",
+		"Connections closed for exceeding the maximum lifetime.", nil, nil)
+	poolIdleDestroys = prometheus.NewDesc("goreader_db_pool_max_idle_destroys_total",
+		"Connections closed for exceeding the maximum idle time.", nil, nil)
+)
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		poolMaxConns, poolTotalConns, poolIdleConns, poolAcquiredConns, poolConstructingConns,
+		poolAcquires, poolEmptyAcquires, poolCanceledAcquires, poolAcquireSeconds,
+		poolNewConns, poolLifetimeDestroys, poolIdleDestroys,
+	} {
+		ch <- desc
+	}
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	// Nothing to report before the database is opened
+	if db.DB == nil {
+		return
+	}
+	stat := db.DB.Stat()
+
+	gauge := func(desc *prometheus.Desc, value int32) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value))
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+
+	gauge(poolMaxConns, stat.MaxConns())
+	gauge(poolTotalConns, stat.TotalConns())
+	gauge(poolIdleConns, stat.IdleConns())
+	gauge(poolAcquiredConns, stat.AcquiredConns())
+	gauge(poolConstructingConns, stat.ConstructingConns())
+	counter(poolAcquires, float64(stat.AcquireCount()))
+	counter(poolEmptyAcquires, float64(stat.EmptyAcquireCount()))
+	counter(poolCanceledAcquires, float64(stat.CanceledAcquireCount()))
+	counter(poolAcquireSeconds, stat.AcquireDuration().Seconds())
+	counter(poolNewConns, float64(stat.NewConnsCount()))
+	counter(poolLifetimeDestroys, float64(stat.MaxLifetimeDestroyCount()))
+	counter(poolIdleDestroys, float64(stat.MaxIdleDestroyCount()))
+}
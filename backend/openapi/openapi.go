@@ -0,0 +1,136 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+)
+
+// The specification is maintained by hand next to this file. Every route
+// registered in main.go must have a matching path here, which the routes
+// test in package main checks.
+//
+//go:embed openapi.yaml
+var specYAML []byte
+
+var (
+	doc      *openapi3.T
+	router   routers.Router
+	specJSON []byte
+)
+
+// streamingBody marks operations that read their request body as a stream.
+// Their bodies aren't validated, which would buffer all of it first.
+const streamingBody = "x-streaming-body"
+
+// Init loads and validates the embedded OpenAPI document
+func Init() error {
+	loader := openapi3.NewLoader()
+	d, err := loader.LoadFromData(specYAML)
+	if err != nil {
+		return fmt.Errorf("failed to load OpenAPI spec: %w", err)
+	}
+
+	if err := d.Validate(loader.Context); err != nil {
+		return fmt.Errorf("invalid OpenAPI spec: %w", err)
+	}
+
+	r, err := legacy.NewRouter(d)
+	if err != nil {
+		return fmt.Errorf("failed to build OpenAPI router: %w", err)
+	}
+
+	j, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to encode OpenAPI spec: %w", err)
+	}
+
+	doc, router, specJSON = d, r, j
+	return nil
+}
+
+// Operation is a method and path template described by the spec
+type Operation struct {
+	Method string
+	Path   string
+}
+
+// Operations lists every operation in the spec
+func Operations() []Operation {
+	var ops []Operation
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			ops = append(ops, Operation{Method: method, Path: path})
+		}
+	}
+	return ops
+}
+
+// Describes reports whether the spec has an operation for method on a path
+// template. Template variable names don't need to match. An empty method
+// matches any operation on the path.
+func Describes(method, path string) bool {
+	item := doc.Paths.Find(path)
+	if item == nil {
+		return false
+	}
+	if method == "" {
+		return len(item.Operations()) > 0
+	}
+	return item.GetOperation(method) != nil
+}
+
+// ServeSpec writes the OpenAPI document as JSON
+func ServeSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(specJSON)
+}
+
+// ValidateRequests checks incoming requests against the OpenAPI document
+// before passing them on. Requests that don't match the spec are rejected
+// with 400; requests for routes the spec doesn't describe, which the mux
+// answers with 404 or 405, are passed on unchecked.
+func ValidateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Preflight requests aren't part of the spec
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route, pathParams, err := router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, streaming := route.Operation.Extensions[streamingBody]
+		options := &openapi3filter.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			ExcludeRequestBody: streaming,
+		}
+		// Keep error messages short instead of dumping the whole schema
+		options.WithCustomSchemaErrorFunc(func(err *openapi3.SchemaError) string {
+			return err.Reason
+		})
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		}
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			http.Error(w, fmt.Sprintf("Request does not match API spec: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/JonSchaeffer/go-reader/openapi"
+)
+
+// recordingMux remembers the patterns registered on it
+type recordingMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (m *recordingMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.HandleFunc(pattern, handler)
+}
+
+func (m *recordingMux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+var templateVar = regexp.MustCompile(`\{[^}]+\}`)
+
+// TestRoutesMatchSpec fails when a route is registered without being
+// described in openapi.yaml, or the spec describes a route that isn't
+// registered
+func TestRoutesMatchSpec(t *testing.T) {
+	if err := openapi.Init(); err != nil {
+		t.Fatal(err)
+	}
+	mux := &recordingMux{ServeMux: http.NewServeMux()}
+	registerRoutes(mux)
+
+	for _, pattern := range mux.patterns {
+		// v1 patterns have no method and route on it in the handler
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			method, path = "", pattern
+		}
+		if !openapi.Describes(method, path) {
+			t.Errorf("route %q is not described in openapi.yaml", pattern)
+		}
+	}
+
+	for _, op := range openapi.Operations() {
+		request := httptest.NewRequest(op.Method, templateVar.ReplaceAllString(op.Path, "1"), nil)
+		if _, pattern := mux.Handler(request); pattern == "" {
+			t.Errorf("openapi.yaml describes %s %s, which isn't registered", op.Method, op.Path)
+		}
+	}
+}
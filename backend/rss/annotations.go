@@ -0,0 +1,311 @@
+package rss
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/JonSchaeffer/go-reader/annotations"
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// Annotation handlers (v2 API)
+
+// annotationBody is the body of POST /api/v2/articles/{id}/annotations and
+// PATCH /api/v2/annotations/{id}. A highlight is anchored either by its text
+// (exact, with optional prefix/suffix context) or by start/end offsets into
+// the article's plain text; the server fills in the rest.
+type annotationBody struct {
+	Kind   *string `json:"kind"`
+	Exact  *string `json:"exact"`
+	Prefix *string `json:"prefix"`
+	Suffix *string `json:"suffix"`
+	Start  *int    `json:"start"`
+	End    *int    `json:"end"`
+	Note   *string `json:"note"`
+	Color  *string `json:"color"`
+}
+
+// anchors reports whether the body sets the highlighted range
+func (b *annotationBody) anchors() bool {
+	return b.Exact != nil || b.Start != nil || b.End != nil
+}
+
+// anchor sets the selector of a highlight from the body, against the
+// article's current text
+func (b *annotationBody) anchor(a *db.Annotation, article *db.Article) error {
+	text := annotations.PlainText(article.Description)
+
+	var sel annotations.Selector
+	var err error
+	if b.Exact != nil {
+		hint := annotations.Selector{Exact: *b.Exact}
+		if b.Prefix != nil {
+			hint.Prefix = *b.Prefix
+		}
+		if b.Suffix != nil {
+			hint.Suffix = *b.Suffix
+		}
+		if b.Start != nil {
+			hint.Start = *b.Start
+		}
+		sel, err = annotations.Complete(text, hint)
+	} else {
+		if b.Start == nil || b.End == nil {
+			return fmt.Errorf("a highlight needs exact or both start and end")
+		}
+		sel, err = annotations.Describe(text, *b.Start, *b.End)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.Exact, a.Prefix, a.Suffix, a.Start, a.End = sel.Exact, sel.Prefix, sel.Suffix, sel.Start, sel.End
+	return nil
+}
+
+// apply copies the note and color from the body onto an annotation
+func (b *annotationBody) apply(a *db.Annotation) {
+	if b.Note != nil {
+		a.Note = *b.Note
+	}
+	if b.Color != nil {
+		a.Color = *b.Color
+	}
+}
+
+func ListArticleAnnotations(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	article, ok := getArticle(w, r, id)
+	if !ok {
+		return
+	}
+
+	list, err := db.GetAnnotationsByArticle(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to get annotations", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []db.Annotation{}
+	}
+	annotations.Resolve(article, list)
+	writeJSON(w, http.StatusOK, list)
+}
+
+func CreateAnnotation(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var reqData annotationBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	article, ok := getArticle(w, r, id)
+	if !ok {
+		return
+	}
+
+	a := db.Annotation{ArticleID: id, Kind: db.AnnotationNote}
+	if reqData.Kind != nil {
+		a.Kind = *reqData.Kind
+	} else if reqData.anchors() {
+		a.Kind = db.AnnotationHighlight
+	}
+
+	switch a.Kind {
+	case db.AnnotationHighlight:
+		if err := reqData.anchor(&a, article); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case db.AnnotationNote:
+		if reqData.anchors() {
+			http.Error(w, "Notes can't have a highlighted range; use kind highlight", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("invalid kind %q", a.Kind), http.StatusBadRequest)
+		return
+	}
+
+	reqData.apply(&a)
+	if a.Kind == db.AnnotationNote && strings.TrimSpace(a.Note) == "" {
+		http.Error(w, "Note text is required", http.StatusBadRequest)
+		return
+	}
+
+	created, err := db.CreateAnnotation(r.Context(), &a)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create annotation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// getAnnotation loads an annotation and its article, re-anchored against
+// the article's current text. It writes a 404 when either doesn't exist.
+func getAnnotation(w http.ResponseWriter, r *http.Request, id int) (*db.Annotation, *db.Article, bool) {
+	a, err := db.GetAnnotationByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Annotation %d not found", id), http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	article, ok := getArticle(w, r, a.ArticleID)
+	if !ok {
+		return nil, nil, false
+	}
+
+	resolved := []db.Annotation{*a}
+	annotations.Resolve(article, resolved)
+	return &resolved[0], article, true
+}
+
+func GetAnnotation(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a, _, ok := getAnnotation(w, r, id)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func UpdateAnnotation(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var patch annotationBody
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	a, article, ok := getAnnotation(w, r, id)
+	if !ok {
+		return
+	}
+
+	if patch.Kind != nil && *patch.Kind != a.Kind {
+		http.Error(w, "The kind of an annotation can't be changed", http.StatusBadRequest)
+		return
+	}
+	if patch.anchors() {
+		if a.Kind != db.AnnotationHighlight {
+			http.Error(w, "Notes can't have a highlighted range", http.StatusBadRequest)
+			return
+		}
+		if err := patch.anchor(a, article); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	patch.apply(a)
+	if a.Kind == db.AnnotationNote && strings.TrimSpace(a.Note) == "" {
+		http.Error(w, "Note text is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.UpdateAnnotation(r.Context(), a); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update annotation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	a, _, ok = getAnnotation(w, r, id)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func DeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteAnnotation(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportArticleAnnotations returns an article's annotations as Markdown
+func ExportArticleAnnotations(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	article, ok := getArticle(w, r, id)
+	if !ok {
+		return
+	}
+
+	list, err := db.GetAnnotationsByArticle(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to get annotations", http.StatusInternalServerError)
+		return
+	}
+	annotations.Resolve(article, list)
+
+	writeMarkdown(w, fmt.Sprintf("article-%d-annotations.md", id), annotations.Markdown(article, list))
+}
+
+// ExportAnnotations returns the annotations of every annotated article as
+// one Markdown document, one section per article
+func ExportAnnotations(w http.ResponseWriter, r *http.Request) {
+	all, err := db.GetAllAnnotations(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get annotations", http.StatusInternalServerError)
+		return
+	}
+
+	// Annotations come ordered by article
+	var sections []string
+	for start := 0; start < len(all); {
+		end := start
+		for end < len(all) && all[end].ArticleID == all[start].ArticleID {
+			end++
+		}
+
+		article, ok := getArticle(w, r, all[start].ArticleID)
+		if !ok {
+			return
+		}
+		annotations.Resolve(article, all[start:end])
+		sections = append(sections, annotations.Markdown(article, all[start:end]))
+		start = end
+	}
+
+	writeMarkdown(w, "annotations.md", strings.Join(sections, "---\n\n"))
+}
+
+func writeMarkdown(w http.ResponseWriter, filename, body string) {
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write([]byte(body))
+}
@@ -0,0 +1,616 @@
+package rss
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/events"
+)
+
+// v2 API handlers. These are registered with method+path patterns, so the
+// method is already checked by the mux and ids come from the URL path
+// instead of the query string. Updates take JSON bodies.
+
+// pathID reads the {id} wildcard from the request path
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID %q", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// queryLimit reads the optional limit query parameter
+func queryLimit(r *http.Request, fallback int) (int, error) {
+	limitParam := r.URL.Query().Get("limit")
+	if limitParam == "" {
+		return fallback, nil
+	}
+	limit, err := strconv.Atoi(limitParam)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit %q", limitParam)
+	}
+	return limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding response", "error", err)
+	}
+}
+
+// Feeds
+
+func ListFeedsV2(w http.ResponseWriter, r *http.Request) {
+	feeds, err := db.GetAllRSS(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get feeds", http.StatusInternalServerError)
+		return
+	}
+	if feeds == nil {
+		feeds = []db.RSS{}
+	}
+	writeJSON(w, http.StatusOK, feeds)
+}
+
+func CreateFeedV2(w http.ResponseWriter, r *http.Request) {
+	var reqData struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	if reqData.URL == "" {
+		http.Error(w, "URL cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	feed, err := CreateFeed(r.Context(), reqData.URL)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error adding RSS feed", "url", reqData.URL, "error", err)
+		http.Error(w, "Failed to add RSS feed", http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, feed)
+}
+
+func GetFeedV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	feed, err := db.GetRSSByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Feed %d not found", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, feed)
+}
+
+// feedPatch is the body of PATCH /api/v2/feeds/{id}. Omitted fields are left
+// unchanged; categoryId may be null to make the feed uncategorized.
+type feedPatch struct {
+	URL         *string         `json:"url"`
+	Title       *string         `json:"title"`
+	Description *string         `json:"description"`
+	FeedSize    *int            `json:"feedSize"`
+	Sync        *int            `json:"sync"`
+	CategoryID  json.RawMessage `json:"categoryId"`
+}
+
+func UpdateFeedV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var patch feedPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.GetRSSByID(r.Context(), id); err != nil {
+		http.Error(w, fmt.Sprintf("Feed %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	if patch.URL != nil {
+		if *patch.URL == "" {
+			http.Error(w, "URL cannot be empty", http.StatusBadRequest)
+			return
+		}
+		if err := db.UpdateRSS(r.Context(), id, "url", *patch.URL); err != nil {
+			http.Error(w, "Error updating RSS URL", http.StatusBadRequest)
+			return
+		}
+	}
+	if patch.Title != nil {
+		if err := db.UpdateRSS(r.Context(), id, "title", *patch.Title); err != nil {
+			http.Error(w, "Error updating RSS title", http.StatusBadRequest)
+			return
+		}
+	}
+	if patch.Description != nil {
+		if err := db.UpdateRSS(r.Context(), id, "description", *patch.Description); err != nil {
+			http.Error(w, "Error updating RSS description", http.StatusBadRequest)
+			return
+		}
+	}
+	if patch.FeedSize != nil {
+		if err := db.UpdateRSS(r.Context(), id, "feedsize", *patch.FeedSize); err != nil {
+			http.Error(w, "Error updating RSS feed size", http.StatusBadRequest)
+			return
+		}
+	}
+	if patch.Sync != nil {
+		if err := db.UpdateRSS(r.Context(), id, "sync", *patch.Sync); err != nil {
+			http.Error(w, "Error updating RSS feed sync", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(patch.CategoryID) > 0 {
+		var categoryID *int
+		if err := json.Unmarshal(patch.CategoryID, &categoryID); err != nil {
+			http.Error(w, "Invalid categoryId", http.StatusBadRequest)
+			return
+		}
+		if err := db.UpdateRSSCategoryID(r.Context(), id, categoryID); err != nil {
+			http.Error(w, "Error updating RSS feed category", http.StatusBadRequest)
+			return
+		}
+	}
+
+	feed, err := db.GetRSSByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load updated feed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, feed)
+}
+
+func DeleteFeedV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteRSSByID(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetFeedStatsV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := db.GetRSSStats(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving stats for RSS feed %d: %v", id, err), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func ListFeedArticlesV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	articles, err := db.GetArticleByRSSID(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, "Failed to get articles", http.StatusInternalServerError)
+		return
+	}
+	if articles == nil {
+		articles = []db.Article{}
+	}
+	if wantsCollapse(r) {
+		if articles, err = collapseClusters(r.Context(), articles); err != nil {
+			http.Error(w, "Failed to collapse duplicates", http.StatusInternalServerError)
+			return
+		}
+	}
+	proxyArticleImages(articles)
+	writeJSON(w, http.StatusOK, articles)
+}
+
+// GetFeedURLRulesV2 returns the query parameters a feed keeps or strips in
+// addition to the built-in tracking parameter list
+func GetFeedURLRulesV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rules, err := db.GetFeedURLRules(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Feed %d not found", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func UpdateFeedURLRulesV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rules db.FeedURLRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	rules.Keep = paramNames(rules.Keep)
+	rules.Strip = paramNames(rules.Strip)
+
+	if err := db.UpdateFeedURLRules(r.Context(), id, &rules); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// paramNames lowercases and trims query parameter names, dropping empty ones
+func paramNames(names []string) []string {
+	cleaned := []string{}
+	for _, name := range names {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !slices.Contains(cleaned, name) {
+			cleaned = append(cleaned, name)
+		}
+	}
+	return cleaned
+}
+
+// Articles
+
+func ListArticlesV2(w http.ResponseWriter, r *http.Request) {
+	articles, err := db.GetAllArticles(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get articles", http.StatusInternalServerError)
+		return
+	}
+	if articles == nil {
+		articles = []db.Article{}
+	}
+	if wantsCollapse(r) {
+		if articles, err = collapseClusters(r.Context(), articles); err != nil {
+			http.Error(w, "Failed to collapse duplicates", http.StatusInternalServerError)
+			return
+		}
+	}
+	proxyArticleImages(articles)
+	writeJSON(w, http.StatusOK, articles)
+}
+
+// SearchArticlesV2 searches titles, content, authors and item categories.
+// ?author= and ?category= narrow the results and can be used without a query.
+func SearchArticlesV2(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	author := r.URL.Query().Get("author")
+	category := r.URL.Query().Get("category")
+	if query == "" && author == "" && category == "" {
+		http.Error(w, "One of the query, author or category parameters is required", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryLimit(r, 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	articles, err := db.SearchArticlesFiltered(r.Context(), query, author, category, limit)
+	if err != nil {
+		http.Error(w, "Failed to search articles", http.StatusInternalServerError)
+		return
+	}
+	if articles == nil {
+		articles = []db.Article{}
+	}
+	if wantsCollapse(r) {
+		if articles, err = collapseClusters(r.Context(), articles); err != nil {
+			http.Error(w, "Failed to collapse duplicates", http.StatusInternalServerError)
+			return
+		}
+	}
+	proxyArticleImages(articles)
+	writeJSON(w, http.StatusOK, articles)
+}
+
+// getArticle loads a single article, writing a 404 when it doesn't exist
+func getArticle(w http.ResponseWriter, r *http.Request, id int) (*db.Article, bool) {
+	articles, err := db.GetSingleArticle(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to get article", http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(articles) == 0 {
+		http.Error(w, fmt.Sprintf("Article %d not found", id), http.StatusNotFound)
+		return nil, false
+	}
+	return &articles[0], true
+}
+
+func GetArticleV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	article, ok := getArticle(w, r, id)
+	if !ok {
+		return
+	}
+
+	// A single article always lists its other copies
+	if article.ClusterID != nil {
+		collapsed, err := collapseClusters(r.Context(), []db.Article{*article})
+		if err != nil {
+			http.Error(w, "Failed to load duplicates", http.StatusInternalServerError)
+			return
+		}
+		article = &collapsed[0]
+	}
+	proxyArticle(article)
+	writeJSON(w, http.StatusOK, article)
+}
+
+// ListArticleRevisionsV2 returns the previous versions of an edited article
+func ListArticleRevisionsV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := getArticle(w, r, id); !ok {
+		return
+	}
+
+	revisions, err := db.GetArticleRevisions(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to get revisions", http.StatusInternalServerError)
+		return
+	}
+	if revisions == nil {
+		revisions = []db.ArticleRevision{}
+	}
+	for i := range revisions {
+		revisions[i].Description = proxyImages(revisions[i].Description)
+	}
+	writeJSON(w, http.StatusOK, revisions)
+}
+
+// articlePatch is the body of PATCH /api/v2/articles/{id}
+type articlePatch struct {
+	Read    *bool `json:"read"`
+	Starred *bool `json:"starred"`
+}
+
+func UpdateArticleV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var patch articlePatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	if patch.Read == nil && patch.Starred == nil {
+		http.Error(w, "At least one field (read, starred) is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := getArticle(w, r, id); !ok {
+		return
+	}
+
+	if patch.Read != nil {
+		if err := db.UpdateArticleReadStatus(r.Context(), id, *patch.Read); err != nil {
+			http.Error(w, fmt.Sprintf("Error updating read status for article %d", id), http.StatusInternalServerError)
+			return
+		}
+		events.Publish(r.Context(), events.ArticleRead, map[string]any{"id": id, "read": *patch.Read})
+	}
+	if patch.Starred != nil {
+		if err := db.UpdateArticleStarredStatus(r.Context(), id, *patch.Starred); err != nil {
+			http.Error(w, fmt.Sprintf("Error updating starred status for article %d", id), http.StatusInternalServerError)
+			return
+		}
+		events.Publish(r.Context(), events.ArticleStarred, map[string]any{"id": id, "starred": *patch.Starred})
+	}
+
+	article, ok := getArticle(w, r, id)
+	if !ok {
+		return
+	}
+	proxyArticle(article)
+	writeJSON(w, http.StatusOK, article)
+}
+
+func DeleteArticleV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteArticle(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Categories
+
+// categoryBody is the body of POST and PATCH /api/v2/categories. For PATCH,
+// omitted fields keep their current value.
+type categoryBody struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+func ListCategoriesV2(w http.ResponseWriter, r *http.Request) {
+	categories, err := db.GetAllCategories(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get categories", http.StatusInternalServerError)
+		return
+	}
+	if categories == nil {
+		categories = []db.Category{}
+	}
+	writeJSON(w, http.StatusOK, categories)
+}
+
+func CreateCategoryV2(w http.ResponseWriter, r *http.Request) {
+	var reqData categoryBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+	if reqData.Name == nil || *reqData.Name == "" {
+		http.Error(w, "Category name is required", http.StatusBadRequest)
+		return
+	}
+
+	color := "#3b82f6"
+	if reqData.Color != nil && *reqData.Color != "" {
+		color = *reqData.Color
+	}
+
+	category, err := db.CreateCategory(r.Context(), *reqData.Name, color)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create category: %v", err), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusCreated, category)
+}
+
+func GetCategoryV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	category, err := db.GetCategoryByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Category %d not found", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, category)
+}
+
+func UpdateCategoryV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var patch categoryBody
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+
+	category, err := db.GetCategoryByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Category %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	if patch.Name != nil {
+		if *patch.Name == "" {
+			http.Error(w, "Category name cannot be empty", http.StatusBadRequest)
+			return
+		}
+		category.Name = *patch.Name
+	}
+	if patch.Color != nil && *patch.Color != "" {
+		category.Color = *patch.Color
+	}
+
+	if err := db.UpdateCategory(r.Context(), id, category.Name, category.Color); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update category: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	category, err = db.GetCategoryByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load updated category", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, category)
+}
+
+func DeleteCategoryV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteCategoryByID(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ListCategoryFeedsV2(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.GetCategoryByID(r.Context(), id); err != nil {
+		http.Error(w, fmt.Sprintf("Category %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	feeds, err := db.GetRSSByCategory(r.Context(), &id)
+	if err != nil {
+		http.Error(w, "Failed to get feeds", http.StatusInternalServerError)
+		return
+	}
+	if feeds == nil {
+		feeds = []db.RSS{}
+	}
+	writeJSON(w, http.StatusOK, feeds)
+}
@@ -0,0 +1,195 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/JonSchaeffer/go-reader/archive"
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// archiveQueue holds the IDs of articles a rule asked to archive. Archiving
+// fetches the page and everything on it, so it runs outside of ingestion.
+var archiveQueue = make(chan int, 256)
+
+// queueArchive schedules an article to be archived by StartArchiver
+func queueArchive(ctx context.Context, articleID int) {
+	select {
+	case archiveQueue <- articleID:
+	default:
+		slog.WarnContext(ctx, "Archive queue is full, not archiving article", "articleId", articleID)
+	}
+}
+
+// StartArchiver archives the articles queued by rules until ctx is done
+func StartArchiver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-archiveQueue:
+			if _, err := archiveArticle(ctx, id); err != nil {
+				slog.ErrorContext(ctx, "Error archiving article", "articleId", id, "error", err)
+			}
+		}
+	}
+}
+
+// ArchiveQueued archives the articles queued so far and returns. Commands
+// that fetch feeds without running StartArchiver call it when done.
+func ArchiveQueued(ctx context.Context) {
+	for {
+		select {
+		case id := <-archiveQueue:
+			if _, err := archiveArticle(ctx, id); err != nil {
+				slog.ErrorContext(ctx, "Error archiving article", "articleId", id, "error", err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// archiveArticle stores a snapshot of the page an article links to,
+// replacing the previous one
+func archiveArticle(ctx context.Context, id int) (*db.Archive, error) {
+	articles, err := db.GetSingleArticle(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(articles) == 0 {
+		return nil, fmt.Errorf("article %d not found", id)
+	}
+	link := articles[0].Link
+
+	snapshot, err := archive.Snapshot(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	key, err := archive.Put(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := db.GetArchive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &db.Archive{ArticleID: id, URL: link, Size: int64(len(snapshot)), Key: key}
+	if err := db.SaveArchive(ctx, a); err != nil {
+		return nil, err
+	}
+	if previous != nil && previous.Key != key {
+		deleteArchiveBlob(ctx, previous.Key)
+	}
+
+	slog.InfoContext(ctx, "Archived article", "articleId", id, "bytes", a.Size)
+	return a, nil
+}
+
+// deleteArchiveBlob removes a snapshot no article refers to anymore.
+// Snapshots stored recently are kept, in case an archive being saved
+// stored the same content; SweepArchives removes them later.
+func deleteArchiveBlob(ctx context.Context, key string) {
+	inUse, err := db.ArchiveKeyInUse(ctx, key)
+	if err != nil || inUse {
+		return
+	}
+	if err := archive.Delete(key); err != nil {
+		slog.ErrorContext(ctx, "Error deleting archived snapshot", "key", key, "error", err)
+	}
+}
+
+// SweepArchives deletes snapshots left behind by deleted articles. Snapshots
+// stored in the last hour are kept, as their archives may not be saved yet.
+func SweepArchives(ctx context.Context) error {
+	keys, err := db.GetArchiveKeys(ctx)
+	if err != nil {
+		return err
+	}
+	removed, err := archive.Sweep(keys)
+	if removed > 0 {
+		slog.InfoContext(ctx, "Deleted unreferenced archived snapshots", "removed", removed)
+	}
+	return err
+}
+
+// Archive handlers (v2 API)
+
+// ArchiveArticle archives an article's page now and returns the snapshot's
+// details
+func ArchiveArticle(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if articles, err := db.GetSingleArticle(r.Context(), id); err != nil || len(articles) == 0 {
+		http.Error(w, fmt.Sprintf("Article %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	a, err := archiveArticle(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error archiving article", "articleId", id, "error", err)
+		http.Error(w, fmt.Sprintf("Failed to archive article: %v", err), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusCreated, a)
+}
+
+// GetArticleArchive serves an article's snapshot as a self-contained HTML
+// page
+func GetArticleArchive(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a, err := db.GetArchive(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load archive", http.StatusInternalServerError)
+		return
+	}
+	if a == nil {
+		http.Error(w, fmt.Sprintf("Article %d has not been archived", id), http.StatusNotFound)
+		return
+	}
+
+	f, err := archive.Open(a.Key)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error opening archived snapshot", "articleId", id, "error", err)
+		http.Error(w, "Archived snapshot is missing", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The snapshot carries the same policy in a <meta>; the header also
+	// covers it being opened directly from the API origin
+	w.Header().Set("Content-Security-Policy",
+		"default-src 'none'; img-src data:; style-src 'unsafe-inline'; font-src data:; media-src data:; sandbox allow-popups allow-popups-to-escape-sandbox")
+	http.ServeContent(w, r, "", a.CreatedAt, f)
+}
+
+func DeleteArticleArchive(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := db.DeleteArchive(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	deleteArchiveBlob(r.Context(), key)
+
+	w.WriteHeader(http.StatusNoContent)
+}
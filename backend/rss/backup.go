@@ -0,0 +1,264 @@
+package rss
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/backup"
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// RestoreStats counts the records a restore added. Records that were
+// already in the database are merged rather than counted, except for
+// articles.
+type RestoreStats struct {
+	Version        int // Backup format version that was read
+	Categories     int
+	Feeds          int
+	Tags           int
+	Webhooks       int
+	Rules          int
+	Articles       int
+	ArticlesMerged int // Articles that existed and had their read/starred state merged
+	Annotations    int
+}
+
+// WriteBackup writes every category, feed, tag, webhook, rule and article
+// to w as a JSON-lines backup. Webhook signing secrets are only included
+// when secrets is true.
+func WriteBackup(ctx context.Context, w io.Writer, secrets bool) error {
+	bw, err := backup.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	categories, err := db.GetAllCategories(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range categories {
+		if err := bw.Write(backup.TypeCategory, db.BackupCategory{Name: c.Name, Color: c.Color}); err != nil {
+			return err
+		}
+	}
+
+	feeds, err := db.GetBackupFeeds(ctx)
+	if err != nil {
+		return err
+	}
+	for _, f := range feeds {
+		if err := bw.Write(backup.TypeFeed, f); err != nil {
+			return err
+		}
+	}
+
+	tags, err := db.GetAllTags(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if err := bw.Write(backup.TypeTag, db.BackupTag{Name: t.Name}); err != nil {
+			return err
+		}
+	}
+
+	webhooks, err := db.GetBackupWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, wh := range webhooks {
+		if !secrets && wh.Secret != "" {
+			wh.Secret, wh.SecretOmitted = "", true
+		}
+		if err := bw.Write(backup.TypeWebhook, wh); err != nil {
+			return err
+		}
+	}
+
+	rules, err := db.GetBackupRules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := bw.Write(backup.TypeRule, rule); err != nil {
+			return err
+		}
+	}
+
+	err = db.EachBackupArticle(ctx, func(a db.BackupArticle) error {
+		return bw.Write(backup.TypeArticle, a)
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Close()
+}
+
+// RestoreBackup merges a backup written by WriteBackup (plain or gzip
+// compressed) into the database. Nothing is restored if any record fails or
+// the backup is incomplete.
+func RestoreBackup(ctx context.Context, r io.Reader) (*RestoreStats, error) {
+	br, err := backup.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	restorer, err := db.BeginRestore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer restorer.Rollback()
+
+	stats := &RestoreStats{Version: br.Header.Version}
+	skipped := map[string]bool{}
+	for {
+		recordType, data, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := restoreRecord(ctx, restorer, recordType, data, stats, skipped); err != nil {
+			return nil, fmt.Errorf("line %d (%s): %w", br.Line(), recordType, err)
+		}
+	}
+
+	if err := restorer.Commit(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func restoreRecord(ctx context.Context, restorer *db.Restorer, recordType string, data json.RawMessage, stats *RestoreStats, skipped map[string]bool) error {
+	count := func(added bool, err error, counter *int) error {
+		if added {
+			*counter++
+		}
+		return err
+	}
+
+	switch recordType {
+	case backup.TypeCategory:
+		var c db.BackupCategory
+		if err := json.Unmarshal(data, &c); err != nil {
+			return err
+		}
+		if c.Name == "" {
+			return fmt.Errorf("category has no name")
+		}
+		added, err := restorer.RestoreCategory(c)
+		return count(added, err, &stats.Categories)
+
+	case backup.TypeFeed:
+		var f db.BackupFeed
+		if err := json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+		if f.URL == "" {
+			return fmt.Errorf("feed has no URL")
+		}
+		added, err := restorer.RestoreFeed(f)
+		return count(added, err, &stats.Feeds)
+
+	case backup.TypeTag:
+		var t db.BackupTag
+		if err := json.Unmarshal(data, &t); err != nil {
+			return err
+		}
+		if t.Name == "" {
+			return fmt.Errorf("tag has no name")
+		}
+		added, err := restorer.RestoreTag(t.Name)
+		return count(added, err, &stats.Tags)
+
+	case backup.TypeWebhook:
+		var wh db.BackupWebhook
+		if err := json.Unmarshal(data, &wh); err != nil {
+			return err
+		}
+		added, err := restorer.RestoreWebhook(wh)
+		return count(added, err, &stats.Webhooks)
+
+	case backup.TypeRule:
+		var rule db.BackupRule
+		if err := json.Unmarshal(data, &rule); err != nil {
+			return err
+		}
+		added, err := restorer.RestoreRule(rule)
+		return count(added, err, &stats.Rules)
+
+	case backup.TypeArticle:
+		var a db.BackupArticle
+		if err := json.Unmarshal(data, &a); err != nil {
+			return err
+		}
+		added, annotations, err := restorer.RestoreArticle(a)
+		if err != nil {
+			return err
+		}
+		if added {
+			stats.Articles++
+		} else {
+			stats.ArticlesMerged++
+		}
+		stats.Annotations += annotations
+		return nil
+
+	default:
+		// Written by a newer build of the same format version
+		if !skipped[recordType] {
+			slog.WarnContext(ctx, "Skipping unknown backup records", "type", recordType)
+			skipped[recordType] = true
+		}
+		return nil
+	}
+}
+
+// Backup handlers (v2 API)
+
+// ExportBackup streams a backup of the whole database, gzip compressed with
+// ?gzip=true
+func ExportBackup(w http.ResponseWriter, r *http.Request) {
+	name := "go-reader-" + time.Now().Format("20060102-150405") + ".jsonl"
+	var out io.Writer = w
+
+	if r.URL.Query().Get("gzip") == "true" {
+		name += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+
+	// The status is sent with the first record, so errors past that point
+	// can only be logged. The API has no authentication, so webhook secrets
+	// are left out.
+	if err := WriteBackup(r.Context(), out, false); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing backup", "error", err)
+	}
+}
+
+// ImportBackup restores the backup in the request body and returns what
+// was added
+func ImportBackup(w http.ResponseWriter, r *http.Request) {
+	stats, err := RestoreBackup(r.Context(), r.Body)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error restoring backup", "error", err)
+		http.Error(w, fmt.Sprintf("Failed to restore backup: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Restored backup", "stats", *stats)
+	writeJSON(w, http.StatusOK, stats)
+}
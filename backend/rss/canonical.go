@@ -0,0 +1,195 @@
+package rss
+
+import (
+	"context"
+	"encoding/base64"
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/dedupe"
+)
+
+// trackingParams are query parameters stripped from links. Parameters
+// starting with utm_ are always included.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true, "igshid": true,
+	"mc_cid": true, "mc_eid": true, "_hsenc": true, "_hsmi": true, "mkt_tok": true, "oly_enc_id": true,
+	"ref": true, "ref_src": true, "ref_url": true, "ncid": true, "sr_share": true, "ocid": true,
+	"__twitter_impression": true, "cmpid": true, "spm": true,
+}
+
+// CanonicalURL cleans up an article link: known redirect wrappers are
+// unwrapped, tracking parameters stripped, the scheme and host lowercased and
+// the fragment dropped. Links that aren't absolute http(s) URLs are returned
+// unchanged.
+func CanonicalURL(raw string, rules *db.FeedURLRules) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return raw
+	}
+
+	// Redirectors may wrap each other
+	for range 3 {
+		target, ok := unwrapRedirect(u)
+		if !ok {
+			break
+		}
+		u = target
+	}
+
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = u.Hostname()
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.RawQuery = stripParams(u.RawQuery, rules)
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// stripParams removes tracking parameters from a raw query string, keeping
+// the order and encoding of the rest
+func stripParams(rawQuery string, rules *db.FeedURLRules) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	var kept []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(pair, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if !isTracking(strings.ToLower(name), rules) {
+			kept = append(kept, pair)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+func isTracking(name string, rules *db.FeedURLRules) bool {
+	if rules != nil {
+		if slices.Contains(rules.Keep, name) {
+			return false
+		}
+		if slices.Contains(rules.Strip, name) {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, "utm_") || trackingParams[name]
+}
+
+// unwrapRedirect returns the destination of known redirect links. FeedBurner
+// links (feedproxy.google.com/~r/...) don't carry their destination; items
+// from FeedBurner feeds have it in feedburner:origLink instead.
+func unwrapRedirect(u *url.URL) (*url.URL, bool) {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	query := u.Query()
+
+	var target string
+	switch {
+	case (host == "google.com" || strings.HasSuffix(host, ".google.com")) && u.Path == "/url":
+		target = query.Get("url")
+		if target == "" {
+			target = query.Get("q")
+		}
+	case host == "news.google.com" && strings.HasPrefix(u.Path, "/news/url"):
+		target = query.Get("url")
+	case host == "news.google.com" && strings.Contains(u.Path, "/articles/"):
+		target = decodeGoogleNewsID(u.Path[strings.LastIndex(u.Path, "/")+1:])
+	case host == "l.facebook.com" || host == "lm.facebook.com":
+		target = query.Get("u")
+	case host == "t.umblr.com":
+		target = query.Get("z")
+	}
+
+	if target == "" {
+		return nil, false
+	}
+	dest, err := url.Parse(target)
+	if err != nil || (dest.Scheme != "http" && dest.Scheme != "https") || dest.Host == "" {
+		return nil, false
+	}
+	return dest, true
+}
+
+// googleNewsURL finds the article URL embedded in a Google News article ID
+var googleNewsURL = regexp.MustCompile(`https?://[\x21-\x7e]+`)
+
+// decodeGoogleNewsID extracts the destination from the base64 encoded ID of
+// a news.google.com/rss/articles/ link. Newer IDs are opaque and are left
+// alone.
+func decodeGoogleNewsID(id string) string {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(id, "="))
+	if err != nil {
+		return ""
+	}
+	match := googleNewsURL.Find(data)
+	if match == nil {
+		return ""
+	}
+	// Drop characters that can't end a URL but may follow it in the encoding
+	return strings.TrimRight(string(match), "\"'<>\\^`{|}")
+}
+
+// contentLink matches link targets in sanitized article HTML, which always
+// has double quoted attributes
+var contentLink = regexp.MustCompile(`(<a\s[^>]*?href=")([^"]*)(")`)
+
+// canonicalizeLinks applies CanonicalURL to the links in article HTML
+func canonicalizeLinks(content string, rules *db.FeedURLRules) string {
+	return contentLink.ReplaceAllStringFunc(content, func(m string) string {
+		parts := contentLink.FindStringSubmatch(m)
+		link := CanonicalURL(html.UnescapeString(parts[2]), rules)
+		return parts[1] + html.EscapeString(link) + parts[3]
+	})
+}
+
+// urlKeyBatch is the number of articles BackfillURLKeys loads at a time
+const urlKeyBatch = 500
+
+// BackfillURLKeys sets the URL key of articles stored before links were
+// canonicalized. Their links were stored as the feed had them, so without
+// a key the first fetch after an upgrade wouldn't recognize items without
+// a GUID whose links carried tracking parameters, and would store them
+// again. Articles whose link has no key are left without one.
+func BackfillURLKeys(ctx context.Context) error {
+	rules := map[int]*db.FeedURLRules{}
+	afterID := 0
+	for {
+		articles, err := db.GetArticlesWithoutURLKey(ctx, afterID, urlKeyBatch)
+		if err != nil {
+			return err
+		}
+		if len(articles) == 0 {
+			return nil
+		}
+
+		for _, a := range articles {
+			afterID = a.ID
+			feedRules, ok := rules[a.RssID]
+			if !ok {
+				if feedRules, err = db.GetFeedURLRules(ctx, a.RssID); err != nil {
+					return err
+				}
+				rules[a.RssID] = feedRules
+			}
+			key := dedupe.URLKey(CanonicalURL(a.Link, feedRules))
+			if key == "" {
+				continue
+			}
+			if err := db.SetArticleURLKey(ctx, a.ID, key); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package rss
+
+import (
+	"testing"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		rules *db.FeedURLRules
+		want  string
+	}{
+		{"unchanged", "https://example.com/post?id=5", nil, "https://example.com/post?id=5"},
+		{"tracking parameters", "https://example.com/post?utm_source=rss&id=5&fbclid=abc", nil, "https://example.com/post?id=5"},
+		{"only tracking parameters", "https://example.com/post?utm_medium=feed", nil, "https://example.com/post"},
+		{"host and fragment", "https://EXAMPLE.com/Post#comments", nil, "https://example.com/Post"},
+		{"default port", "http://example.com:80/post", nil, "http://example.com/post"},
+		{"other port", "https://example.com:8443/post", nil, "https://example.com:8443/post"},
+		{"surrounding space", "  https://example.com/post ", nil, "https://example.com/post"},
+		{"not http", "mailto:someone@example.com", nil, "mailto:someone@example.com"},
+		{"relative", "/post", nil, "/post"},
+		{"google redirect", "https://www.google.com/url?q=https://example.com/post%3Futm_source%3Dx", nil, "https://example.com/post"},
+		{"google news redirect", "https://news.google.com/news/url?url=https://example.com/post", nil, "https://example.com/post"},
+		{"facebook redirect", "https://l.facebook.com/l.php?u=https%3A%2F%2Fexample.com%2Fpost&h=x", nil, "https://example.com/post"},
+		{"tumblr redirect", "https://t.umblr.com/redirect?z=https%3A%2F%2Fexample.com%2Fpost", nil, "https://example.com/post"},
+		{"redirect to other scheme", "https://www.google.com/url?q=javascript:alert(1)", nil, "https://www.google.com/url?q=javascript:alert(1)"},
+		{"feed keeps parameter", "https://example.com/post?ref=home", &db.FeedURLRules{Keep: []string{"ref"}}, "https://example.com/post?ref=home"},
+		{"feed strips parameter", "https://example.com/post?id=5&session=1", &db.FeedURLRules{Strip: []string{"session"}}, "https://example.com/post?id=5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalURL(tt.raw, tt.rules); got != tt.want {
+				t.Errorf("CanonicalURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawQuery string
+		rules    *db.FeedURLRules
+		want     string
+	}{
+		{"empty", "", nil, ""},
+		{"keeps order and encoding", "b=2&a=%20x&c", nil, "b=2&a=%20x&c"},
+		{"utm prefix", "utm_source=rss&UTM_Campaign=x&id=1", nil, "id=1"},
+		{"known parameters", "gclid=1&id=1&mc_eid=2&spm=3", nil, "id=1"},
+		{"encoded name", "utm%5Fsource=rss&id=1", nil, "id=1"},
+		{"empty pairs", "&&id=1&", nil, "id=1"},
+		{"keep wins over default", "fbclid=1&id=1", &db.FeedURLRules{Keep: []string{"fbclid"}}, "fbclid=1&id=1"},
+		{"strip", "id=1&page=2", &db.FeedURLRules{Strip: []string{"page"}}, "id=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripParams(tt.rawQuery, tt.rules); got != tt.want {
+				t.Errorf("stripParams(%q) = %q, want %q", tt.rawQuery, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeGoogleNewsID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want string
+	}{
+		{"embedded url", "CBMiK2h0dHBzOi8vZXhhbXBsZS5jb20vbmV3cy9zdG9yeS0xP2lkPTXSAQA", "https://example.com/news/story-1?id=5"},
+		{"padded", "CBMiK2h0dHBzOi8vZXhhbXBsZS5jb20vbmV3cy9zdG9yeS0xP2lkPTXSAQA=", "https://example.com/news/story-1?id=5"},
+		{"opaque", "CBMiAQIDBAUGBwgJ", ""},
+		{"not base64", "not*base64", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeGoogleNewsID(tt.id); got != tt.want {
+				t.Errorf("decodeGoogleNewsID(%q) = %q, want %q", tt.id, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package rss
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/dedupe"
+	"github.com/JonSchaeffer/go-reader/events"
+	"github.com/JonSchaeffer/go-reader/metrics"
+	"github.com/JonSchaeffer/go-reader/rules"
+)
+
+// duplicateWindow is how far back content fingerprints are compared.
+// Matching URL keys are found regardless of age.
+const duplicateWindow = 72 * time.Hour
+
+// clusterArticle fingerprints a newly stored article and, when another
+// feed already has the same story, puts both in one cluster. A copy of a
+// story that was already read arrives read.
+func clusterArticle(ctx context.Context, article *db.Article) {
+	urlKey := dedupe.URLKey(article.Link)
+	simhash := dedupe.SimHash(article.Description)
+
+	if err := db.SetArticleFingerprint(ctx, article.ID, urlKey, simhash); err != nil {
+		slog.ErrorContext(ctx, "Error fingerprinting article", "articleId", article.ID, "error", err)
+		return
+	}
+	if urlKey == "" && simhash == 0 {
+		return
+	}
+
+	candidates, err := db.GetDuplicateCandidates(ctx, article.RssID, urlKey, time.Now().Add(-duplicateWindow))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading duplicate candidates", "articleId", article.ID, "error", err)
+		return
+	}
+
+	match, best := -1, dedupe.MaxDistance+1
+	for i, c := range candidates {
+		if urlKey != "" && c.URLKey == urlKey {
+			match = i
+			break
+		}
+		if dedupe.Similar(simhash, c.SimHash) {
+			if d := dedupe.Distance(simhash, c.SimHash); d < best {
+				match, best = i, d
+			}
+		}
+	}
+	if match < 0 {
+		return
+	}
+
+	clusterID, err := db.JoinCluster(ctx, article.ID, candidates[match])
+	if err != nil {
+		slog.ErrorContext(ctx, "Error clustering article", "articleId", article.ID, "error", err)
+		return
+	}
+	article.ClusterID = &clusterID
+	slog.InfoContext(ctx, "Article is a duplicate", "articleId", article.ID, "duplicateOf", candidates[match].ID)
+
+	if candidates[match].Read && !article.Read {
+		if err := db.UpdateArticleReadStatus(ctx, article.ID, true); err != nil {
+			slog.ErrorContext(ctx, "Error marking duplicate article read", "articleId", article.ID, "error", err)
+		} else {
+			article.Read = true
+		}
+	}
+}
+
+// wantsCollapse reports whether a listing should collapse clusters
+// (?collapse=true)
+func wantsCollapse(r *http.Request) bool {
+	return r.URL.Query().Get("collapse") == "true"
+}
+
+// collapseClusters keeps the first article of every cluster in a listing,
+// dropping the other copies and listing them in AlsoIn instead
+func collapseClusters(ctx context.Context, articles []db.Article) ([]db.Article, error) {
+	var clusterIDs []int
+	for _, article := range articles {
+		if article.ClusterID != nil {
+			clusterIDs = append(clusterIDs, *article.ClusterID)
+		}
+	}
+
+	members, err := db.GetClusterMembers(ctx, clusterIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	collapsed := articles[:0]
+	seen := map[int]bool{}
+	for _, article := range articles {
+		if article.ClusterID != nil {
+			if seen[*article.ClusterID] {
+				continue
+			}
+			seen[*article.ClusterID] = true
+
+			for _, ref := range members[*article.ClusterID] {
+				if ref.ID != article.ID {
+					article.AlsoIn = append(article.AlsoIn, ref)
+				}
+			}
+		}
+		collapsed = append(collapsed, article)
+	}
+	return collapsed, nil
+}
+
+// updateStoredItem brings a stored article up to date with the feed item it
+// came from. Unchanged items are skipped before any processing; edited ones
+// replace the stored title and content, are fingerprinted again, get their
+// lead image looked up again and go through the rules that match only the
+// new version. It returns the result for the fetch metrics.
+func updateStoredItem(ctx context.Context, stored *db.StoredItem, item Item, link, hash string,
+	processor *ContentProcessor, feedRules []db.Rule) string {
+	if stored.ContentHash == hash {
+		return metrics.ItemUnchanged
+	}
+
+	processedDescription := processor.ProcessContent(item.content())
+	if stored.Title == item.Title && stored.Description == processedDescription {
+		// Stored before content hashes existed, or only changed in ways
+		// processing removes
+		if err := db.SetArticleContentHash(ctx, stored.ID, hash, item.author(), item.categories()); err != nil {
+			slog.ErrorContext(ctx, "Error saving content hash", "articleId", stored.ID, "error", err)
+		}
+		return metrics.ItemUnchanged
+	}
+
+	if err := db.UpdateArticleContent(ctx, stored.ID, item.Title, processedDescription, item.author(), item.categories(),
+		hash, dedupe.URLKey(link), dedupe.SimHash(processedDescription), config.KeepRevisions); err != nil {
+		slog.ErrorContext(ctx, "Error updating article", "articleId", stored.ID, "error", err)
+		return metrics.ItemFailed
+	}
+	slog.InfoContext(ctx, "Article was edited by the publisher and updated", "articleId", stored.ID, "title", item.Title)
+
+	articles, err := db.GetSingleArticle(ctx, stored.ID)
+	if err != nil || len(articles) == 0 {
+		slog.ErrorContext(ctx, "Error loading updated article", "articleId", stored.ID, "error", err)
+		return metrics.ItemUpdated
+	}
+	article := &articles[0]
+	setLeadImage(ctx, article, item, processedDescription, link)
+	applyEditRules(ctx, article, stored, item, link, feedRules)
+
+	events.Publish(ctx, events.ArticleUpdated, map[string]any{
+		"id":    stored.ID,
+		"title": item.Title,
+	})
+	return metrics.ItemUpdated
+}
+
+// applyEditRules applies the rules that match an edited article but didn't
+// match its previous version, so an edit doesn't star, tag or deliver an
+// article twice. A skip rule can't undo storing the article, so it marks
+// the article read instead.
+func applyEditRules(ctx context.Context, article *db.Article, stored *db.StoredItem, item Item, link string, feedRules []db.Rule) {
+	before := rules.Evaluate(feedRules, rules.Candidate{
+		Title:   stored.Title,
+		Content: stored.Description,
+		Author:  item.author(),
+		Link:    link,
+	})
+	var newRules []db.Rule
+	for _, rule := range feedRules {
+		if !slices.Contains(before.Matched, rule.ID) {
+			newRules = append(newRules, rule)
+		}
+	}
+
+	result := rules.Evaluate(newRules, rules.Candidate{
+		Title:   article.Title,
+		Content: article.Description,
+		Author:  article.Author,
+		Link:    link,
+	})
+	if len(result.Matched) == 0 {
+		return
+	}
+
+	if (result.Skip || result.MarkRead) && !article.Read {
+		if err := db.UpdateArticleReadStatus(ctx, article.ID, true); err != nil {
+			slog.ErrorContext(ctx, "Error marking article read from rule", "articleId", article.ID, "error", err)
+		} else {
+			article.Read = true
+		}
+	}
+	applyRuleResult(ctx, article, result)
+}
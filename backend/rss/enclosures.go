@@ -0,0 +1,205 @@
+package rss
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// itemEnclosure is an RSS <enclosure>
+type itemEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+// mediaContent is a Media RSS <media:content>
+type mediaContent struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Medium   string `xml:"medium,attr"`
+	FileSize string `xml:"fileSize,attr"`
+	Duration string `xml:"duration,attr"`
+}
+
+type mediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+// mediaGroup wraps alternative renditions of the same media (YouTube feeds
+// put everything in one)
+type mediaGroup struct {
+	Contents   []mediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnails []mediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+// enclosures collects an item's media from <enclosure>, media:content
+// (directly or in a media:group) and the iTunes tags, one per URL
+func (item *Item) enclosures() []db.Enclosure {
+	var enclosures []db.Enclosure
+	seen := map[string]int{}
+
+	add := func(e db.Enclosure) {
+		e.URL = strings.TrimSpace(e.URL)
+		if e.URL == "" {
+			return
+		}
+		// The same file listed twice: fill in whatever the first one lacked
+		if i, ok := seen[e.URL]; ok {
+			existing := &enclosures[i]
+			if existing.MimeType == "" {
+				existing.MimeType = e.MimeType
+			}
+			if existing.Length == 0 {
+				existing.Length = e.Length
+			}
+			if existing.Duration == 0 {
+				existing.Duration = e.Duration
+			}
+			return
+		}
+		seen[e.URL] = len(enclosures)
+		enclosures = append(enclosures, e)
+	}
+
+	for _, e := range item.Enclosures {
+		add(db.Enclosure{URL: e.URL, MimeType: e.Type, Length: parseLength(e.Length)})
+	}
+
+	contents := item.MediaContents
+	thumbnails := item.MediaThumbnails
+	for _, group := range item.MediaGroups {
+		contents = append(contents, group.Contents...)
+		thumbnails = append(thumbnails, group.Thumbnails...)
+	}
+	for _, c := range contents {
+		// Images are article pictures, not media to play
+		if c.Medium == "image" || strings.HasPrefix(c.Type, "image/") {
+			continue
+		}
+		add(db.Enclosure{
+			URL:      c.URL,
+			MimeType: c.Type,
+			Length:   parseLength(c.FileSize),
+			Duration: parseDuration(c.Duration),
+		})
+	}
+
+	thumbnail := item.ITunesImage.Href
+	if len(thumbnails) > 0 && thumbnails[0].URL != "" {
+		thumbnail = thumbnails[0].URL
+	}
+	duration := parseDuration(item.ITunesDuration)
+
+	for i := range enclosures {
+		if enclosures[i].ThumbnailURL == "" {
+			enclosures[i].ThumbnailURL = thumbnail
+		}
+		if enclosures[i].Duration == 0 {
+			enclosures[i].Duration = duration
+		}
+	}
+	return enclosures
+}
+
+func parseLength(s string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// parseDuration reads a duration in seconds, MM:SS or HH:MM:SS as used by
+// itunes:duration. Unparseable durations are 0.
+func parseDuration(s string) int {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+
+	seconds := 0
+	for _, part := range strings.Split(s, ":") {
+		// Some feeds send fractional seconds
+		whole, _, _ := strings.Cut(part, ".")
+		n, err := strconv.Atoi(whole)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+// Enclosure handlers (v2 API)
+
+func GetEnclosure(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	enclosure, err := db.GetEnclosureByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load enclosure", http.StatusInternalServerError)
+		return
+	}
+	if enclosure == nil {
+		http.Error(w, fmt.Sprintf("Enclosure %d not found", id), http.StatusNotFound)
+		return
+	}
+	proxyEnclosure(enclosure)
+	writeJSON(w, http.StatusOK, enclosure)
+}
+
+// playbackBody is the body of PUT /api/v2/enclosures/{id}/position
+type playbackBody struct {
+	Position  *int `json:"position"`
+	Completed bool `json:"completed"`
+}
+
+// UpdatePlaybackPosition saves how far an enclosure has been played so
+// another device can resume from there
+func UpdatePlaybackPosition(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var reqData playbackBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	if reqData.Position == nil || *reqData.Position < 0 {
+		http.Error(w, "position (seconds, >= 0) is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.UpdatePlaybackPosition(r.Context(), id, *reqData.Position, reqData.Completed); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	enclosure, err := db.GetEnclosureByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load enclosure", http.StatusInternalServerError)
+		return
+	}
+	if enclosure == nil {
+		http.Error(w, fmt.Sprintf("Enclosure %d not found", id), http.StatusNotFound)
+		return
+	}
+	proxyEnclosure(enclosure)
+	writeJSON(w, http.StatusOK, enclosure)
+}
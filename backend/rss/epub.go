@@ -0,0 +1,170 @@
+package rss
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/epub"
+	"github.com/JonSchaeffer/go-reader/images"
+)
+
+const maxEPUBArticles = 200
+
+// ExportEPUB builds an EPUB of the articles given by ?ids= (a comma
+// separated list, in reading order), ?categoryId= or ?tagId=. Category and
+// tag books hold the newest ?limit= articles, oldest first.
+func ExportEPUB(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := queryLimit(r, 50)
+	if err != nil || limit > maxEPUBArticles {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxEPUBArticles), http.StatusBadRequest)
+		return
+	}
+
+	selectors := 0
+	for _, param := range []string{"ids", "categoryId", "tagId"} {
+		if query.Get(param) != "" {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		http.Error(w, "Exactly one of ids, categoryId or tagId is required", http.StatusBadRequest)
+		return
+	}
+
+	var articles []db.Article
+	title := query.Get("title")
+
+	switch {
+	case query.Get("ids") != "":
+		var ids []int
+		for _, part := range strings.Split(query.Get("ids"), ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid article ID %q", part), http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) > maxEPUBArticles {
+			http.Error(w, fmt.Sprintf("At most %d articles can be exported", maxEPUBArticles), http.StatusBadRequest)
+			return
+		}
+		articles, err = db.GetArticlesByIDs(r.Context(), ids)
+		if title == "" {
+			title = "Reading List"
+		}
+
+	case query.Get("categoryId") != "":
+		id, convErr := strconv.Atoi(query.Get("categoryId"))
+		if convErr != nil {
+			http.Error(w, "invalid categoryId", http.StatusBadRequest)
+			return
+		}
+		category, getErr := db.GetCategoryByID(r.Context(), id)
+		if getErr != nil {
+			http.Error(w, fmt.Sprintf("Category %d not found", id), http.StatusNotFound)
+			return
+		}
+		articles, err = db.GetArticlesByCategory(r.Context(), id, limit)
+		slices.Reverse(articles)
+		if title == "" {
+			title = category.Name
+		}
+
+	default:
+		id, convErr := strconv.Atoi(query.Get("tagId"))
+		if convErr != nil {
+			http.Error(w, "invalid tagId", http.StatusBadRequest)
+			return
+		}
+		tag, getErr := db.GetTagByID(r.Context(), id)
+		if getErr != nil {
+			http.Error(w, fmt.Sprintf("Tag %d not found", id), http.StatusNotFound)
+			return
+		}
+		articles, err = db.GetArticlesByTag(r.Context(), id, limit)
+		slices.Reverse(articles)
+		if title == "" {
+			title = tag.Name
+		}
+	}
+
+	if err != nil {
+		http.Error(w, "Failed to get articles", http.StatusInternalServerError)
+		return
+	}
+	if len(articles) == 0 {
+		http.Error(w, "No articles to export", http.StatusNotFound)
+		return
+	}
+
+	book := epub.Book{Title: title, Language: "en"}
+	processor := NewContentProcessor()
+	feeds := map[int]string{}
+	for _, article := range articles {
+		book.Chapters = append(book.Chapters, epub.Chapter{
+			Title:   article.Title,
+			Byline:  byline(r.Context(), article, feeds),
+			Link:    article.Link,
+			Content: processor.ProcessContent(article.Description),
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := epub.Write(&buf, book, epubImageLoader(r.Context())); err != nil {
+		slog.ErrorContext(r.Context(), "Error building EPUB", "title", title, "error", err)
+		http.Error(w, "Failed to build EPUB", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.epub"`, fileSlug(title)))
+	w.Write(buf.Bytes())
+}
+
+// epubImageLoader fetches content images for embedding, through the image
+// cache. Loads stop when ctx is done.
+func epubImageLoader(ctx context.Context) epub.ImageLoader {
+	return func(imageURL string) ([]byte, string, error) {
+		return images.Load(ctx, imageURL)
+	}
+}
+
+// byline describes where an article came from: author, feed and date
+func byline(ctx context.Context, article db.Article, feeds map[int]string) string {
+	feedTitle, ok := feeds[article.RssID]
+	if !ok {
+		if feed, err := db.GetRSSByID(ctx, article.RssID); err == nil {
+			feedTitle = feed.Title
+		}
+		feeds[article.RssID] = feedTitle
+	}
+
+	var parts []string
+	for _, part := range []string{article.Author, feedTitle, article.PublishDate} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// fileSlug turns a title into a safe file name
+func fileSlug(title string) string {
+	slug := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if slug == "" {
+		return "articles"
+	}
+	return slug
+}
@@ -0,0 +1,205 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/events"
+)
+
+// Fetch state of this process. Failing feeds are used to report when a feed
+// starts failing and when it recovers; all of it is shown by the status
+// endpoints.
+var (
+	feedStatusMu   sync.Mutex
+	feedErrors     = map[int]feedFailure{} // Feeds whose last fetch failed
+	lastFetched    = map[int]time.Time{}
+	fetcherStarted time.Time // Zero when StartRSSFetcher isn't running
+	cycleStarted   time.Time
+	cycleFinished  time.Time
+)
+
+type feedFailure struct {
+	err   string
+	since time.Time
+}
+
+// minStallTime is the least time a fetch cycle may take before the fetcher
+// is considered wedged
+const minStallTime = 15 * time.Minute
+
+// FetcherStatus describes the background fetcher
+type FetcherStatus struct {
+	Running         bool          `json:"running"` // StartRSSFetcher is running in this process
+	Interval        string        `json:"interval"`
+	CycleInProgress bool          `json:"cycleInProgress"`
+	CycleStartedAt  *time.Time    `json:"cycleStartedAt"`
+	CycleFinishedAt *time.Time    `json:"cycleFinishedAt"`
+	FeedsDue        int           `json:"feedsDue"` // Feeds not fetched within the last interval
+	FailingFeeds    []FailingFeed `json:"failingFeeds"`
+}
+
+// FailingFeed is a feed whose last fetch failed
+type FailingFeed struct {
+	ID    int       `json:"id"`
+	Title string    `json:"title"`
+	URL   string    `json:"url"`
+	Error string    `json:"error"`
+	Since time.Time `json:"since"` // When the feed started failing
+}
+
+// recordFeedResult publishes feed.failed when a feed's fetch fails (or fails
+// with a different error) and feed.recovered on the first success afterwards
+func recordFeedResult(ctx context.Context, feedID int, err error) {
+	// A fetch interrupted by shutdown says nothing about the feed
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	feedStatusMu.Lock()
+	now := time.Now()
+	lastFetched[feedID] = now
+	previous, failing := feedErrors[feedID]
+	if err != nil {
+		failure := feedFailure{err: err.Error(), since: now}
+		if failing {
+			failure.since = previous.since
+		}
+		feedErrors[feedID] = failure
+	} else {
+		delete(feedErrors, feedID)
+	}
+	feedStatusMu.Unlock()
+
+	switch {
+	case err != nil && (!failing || previous.err != err.Error()):
+		events.Publish(ctx, events.FeedFailed, map[string]any{"feedId": feedID, "error": err.Error()})
+	case err == nil && failing:
+		events.Publish(ctx, events.FeedRecovered, map[string]any{"feedId": feedID})
+	}
+}
+
+// recordFetch adds a fetch to the feed's history and drops entries older
+// than the configured retention
+func recordFetch(ctx context.Context, fetch *db.FetchLog, duration time.Duration, err error) {
+	// As with recordFeedResult, a fetch interrupted by shutdown isn't recorded
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	fetch.DurationMs = duration.Milliseconds()
+	if err != nil {
+		fetch.Error = err.Error()
+	}
+	keepSince := time.Now().AddDate(0, 0, -config.FetchHistoryDays)
+	if err := db.AddFetchLog(ctx, fetch, keepSince); err != nil {
+		slog.ErrorContext(ctx, "Error recording fetch", "error", err)
+	}
+}
+
+// fetcherRunning records that StartRSSFetcher started or stopped
+func fetcherRunning(running bool) {
+	feedStatusMu.Lock()
+	defer feedStatusMu.Unlock()
+	if running {
+		fetcherStarted = time.Now()
+	} else {
+		fetcherStarted = time.Time{}
+	}
+}
+
+// recordCycle records the start or end of a fetch of all feeds
+func recordCycle(start bool) {
+	feedStatusMu.Lock()
+	defer feedStatusMu.Unlock()
+	if start {
+		cycleStarted = time.Now()
+	} else {
+		cycleFinished = time.Now()
+	}
+}
+
+// stallTime is how long a fetch cycle may run, or the fetcher may go
+// without starting one, before it's considered wedged
+func stallTime() time.Duration {
+	return max(3*config.FetchInterval, minStallTime)
+}
+
+// FetcherStalled returns an error when the background fetcher is running
+// but stuck in a fetch cycle or no longer starting new ones
+func FetcherStalled() error {
+	feedStatusMu.Lock()
+	defer feedStatusMu.Unlock()
+
+	if fetcherStarted.IsZero() {
+		return nil
+	}
+	now := time.Now()
+	if cycleStarted.After(cycleFinished) {
+		if now.Sub(cycleStarted) > stallTime() {
+			return fmt.Errorf("fetch cycle running since %s", cycleStarted.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	last := cycleStarted
+	if last.IsZero() {
+		last = fetcherStarted
+	}
+	if now.Sub(last) > config.FetchInterval+stallTime() {
+		return fmt.Errorf("no fetch cycle started since %s", last.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// GetFetcherStatus reports the fetcher's progress and the feeds that are
+// due or failing
+func GetFetcherStatus(ctx context.Context) (*FetcherStatus, error) {
+	feeds, err := db.GetAllRSS(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	feedStatusMu.Lock()
+	defer feedStatusMu.Unlock()
+
+	status := &FetcherStatus{
+		Running:         !fetcherStarted.IsZero(),
+		Interval:        config.FetchInterval.String(),
+		CycleInProgress: cycleStarted.After(cycleFinished),
+		FailingFeeds:    []FailingFeed{},
+	}
+	if !cycleStarted.IsZero() {
+		started := cycleStarted
+		status.CycleStartedAt = &started
+	}
+	if !cycleFinished.IsZero() {
+		finished := cycleFinished
+		status.CycleFinishedAt = &finished
+	}
+
+	dueBefore := time.Now().Add(-config.FetchInterval)
+	for _, feed := range feeds {
+		if fetched, ok := lastFetched[feed.ID]; !ok || fetched.Before(dueBefore) {
+			status.FeedsDue++
+		}
+		if failure, ok := feedErrors[feed.ID]; ok {
+			status.FailingFeeds = append(status.FailingFeeds, FailingFeed{
+				ID:    feed.ID,
+				Title: feed.Title,
+				URL:   feed.URL,
+				Error: failure.err,
+				Since: failure.since,
+			})
+		}
+	}
+	sort.Slice(status.FailingFeeds, func(i, j int) bool {
+		return status.FailingFeeds[i].Since.Before(status.FailingFeeds[j].Since)
+	})
+	return status, nil
+}
@@ -0,0 +1,86 @@
+package rss
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/images"
+)
+
+// imgTag matches images in sanitized article HTML, which escapes ">" in
+// attribute values and always double quotes them
+var imgTag = regexp.MustCompile(`<img\s[^>]*>`)
+
+// imgSource matches the src and srcset attributes of an image tag
+var imgSource = regexp.MustCompile(`(\s(src|srcset)=")([^"]*)(")`)
+
+// proxyArticleImages points the images of articles about to be served at
+// the image proxy. Articles are stored with the original image URLs, so the
+// proxy's address and key can change without rewriting stored articles.
+func proxyArticleImages(articles []db.Article) {
+	for i := range articles {
+		proxyArticle(&articles[i])
+	}
+}
+
+// proxyArticle points the content images, lead image and enclosure
+// thumbnails of an article at the image proxy
+func proxyArticle(article *db.Article) {
+	article.Description = proxyImages(article.Description)
+	article.LeadImage = images.ProxyURL(article.LeadImage)
+	for i := range article.Enclosures {
+		proxyEnclosure(&article.Enclosures[i])
+	}
+}
+
+// proxyEnclosure points the thumbnail of an enclosure at the image proxy
+func proxyEnclosure(enclosure *db.Enclosure) {
+	enclosure.ThumbnailURL = images.ProxyURL(enclosure.ThumbnailURL)
+}
+
+// proxyImages points the images in article HTML at the image proxy
+func proxyImages(content string) string {
+	return rewriteImages(content, images.ProxyURL)
+}
+
+// checkSrcsets drops srcset candidates that aren't http(s) URLs, since the
+// sanitizer only checks src
+func checkSrcsets(content string) string {
+	return rewriteImages(content, func(imageURL string) string { return imageURL })
+}
+
+// rewriteImages applies rewrite to the src and srcset URLs of every image,
+// dropping srcset candidates that aren't http(s) URLs afterwards
+func rewriteImages(content string, rewrite func(string) string) string {
+	return imgTag.ReplaceAllStringFunc(content, func(tag string) string {
+		return imgSource.ReplaceAllStringFunc(tag, func(attr string) string {
+			parts := imgSource.FindStringSubmatch(attr)
+			value := html.UnescapeString(parts[3])
+			if parts[2] == "srcset" {
+				value = rewriteSrcset(value, rewrite)
+			} else {
+				value = rewrite(value)
+			}
+			return parts[1] + html.EscapeString(value) + parts[4]
+		})
+	})
+}
+
+// rewriteSrcset rewrites the URLs of a srcset ("a.jpg 1x, b.jpg 2x")
+func rewriteSrcset(srcset string, rewrite func(string) string) string {
+	var candidates []string
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = rewrite(fields[0])
+		if !strings.HasPrefix(fields[0], "http://") && !strings.HasPrefix(fields[0], "https://") {
+			continue
+		}
+		candidates = append(candidates, strings.Join(fields, " "))
+	}
+	return strings.Join(candidates, ", ")
+}
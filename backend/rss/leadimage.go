@@ -0,0 +1,353 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/images"
+	"golang.org/x/net/html"
+)
+
+// minLeadImageWidth and minLeadImageHeight are the smallest content image
+// used as a lead image. Smaller ones are icons, emoji and tracking pixels.
+const (
+	minLeadImageWidth  = 200
+	minLeadImageHeight = 100
+)
+
+// maxContentImages is how many content images are considered before giving up
+const maxContentImages = 5
+
+// maxPageHead is how much of a linked page is read looking for og:image
+const maxPageHead = 512 << 10
+
+// Thumbnail widths served by GET /api/v2/articles/{id}/thumbnail
+const (
+	defaultThumbnailWidth = 320
+	maxThumbnailWidth     = 1024
+)
+
+// thumbnailWidths are the widths thumbnails are made in. Requested widths
+// are rounded up to one of them, so an image is cached in a few sizes only.
+var thumbnailWidths = []int{160, 320, 640, maxThumbnailWidth}
+
+// snapThumbnailWidth rounds a requested width up to a thumbnail width
+func snapThumbnailWidth(width int) int {
+	for _, w := range thumbnailWidths {
+		if width <= w {
+			return w
+		}
+	}
+	return maxThumbnailWidth
+}
+
+var pageClient = &http.Client{Timeout: 10 * time.Second}
+
+// leadImageJob is an article whose lead image has to be looked up on the web
+type leadImageJob struct {
+	articleID int
+	content   string
+	link      string
+}
+
+// leadImageQueue holds articles without a feed-provided image. Looking one
+// up fetches the linked page and measures images, so it runs outside of
+// ingestion where a slow publisher can't hold up the other feeds.
+var leadImageQueue = make(chan leadImageJob, 256)
+
+// setLeadImage picks the picture shown with an article. The feed's own media
+// thumbnail or image enclosure is stored right away; otherwise the article
+// is queued for findLeadImage.
+func setLeadImage(ctx context.Context, article *db.Article, item Item, content, link string) {
+	image := item.mediaImage()
+	if image == "" {
+		select {
+		case leadImageQueue <- leadImageJob{articleID: article.ID, content: content, link: link}:
+		default:
+			slog.WarnContext(ctx, "Lead image queue is full, not looking up lead image", "articleId", article.ID)
+		}
+		return
+	}
+
+	image = resolveURL(link, image)
+	if err := db.SetArticleLeadImage(ctx, article.ID, image); err != nil {
+		slog.ErrorContext(ctx, "Error saving lead image", "articleId", article.ID, "error", err)
+		return
+	}
+	article.LeadImage = image
+}
+
+// StartLeadImageFinder looks up the lead images of queued articles until
+// ctx is done
+func StartLeadImageFinder(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-leadImageQueue:
+			findLeadImage(ctx, job)
+		}
+	}
+}
+
+// FindLeadImagesQueued looks up the lead images queued so far and returns.
+// Commands that fetch feeds without running StartLeadImageFinder call it
+// when done.
+func FindLeadImagesQueued(ctx context.Context) {
+	for ctx.Err() == nil {
+		select {
+		case job := <-leadImageQueue:
+			findLeadImage(ctx, job)
+		default:
+			return
+		}
+	}
+}
+
+// findLeadImage stores the og:image of the linked page as the article's lead
+// image, or else the first sufficiently large image in its sanitized content
+func findLeadImage(ctx context.Context, job leadImageJob) {
+	image, err := pageImage(ctx, job.link)
+	if err != nil {
+		slog.WarnContext(ctx, "Error looking for og:image", "articleId", job.articleID, "link", job.link, "error", err)
+	}
+	if image == "" {
+		image = contentImage(ctx, job.content, job.link)
+	}
+	if image == "" {
+		return
+	}
+	if err := db.SetArticleLeadImage(ctx, job.articleID, image); err != nil {
+		slog.ErrorContext(ctx, "Error saving lead image", "articleId", job.articleID, "error", err)
+	}
+}
+
+// mediaImage returns the first picture the feed item itself provides
+func (item *Item) mediaImage() string {
+	thumbnails := item.MediaThumbnails
+	contents := item.MediaContents
+	for _, group := range item.MediaGroups {
+		thumbnails = append(thumbnails, group.Thumbnails...)
+		contents = append(contents, group.Contents...)
+	}
+
+	for _, t := range thumbnails {
+		if u := strings.TrimSpace(t.URL); u != "" {
+			return u
+		}
+	}
+	for _, c := range contents {
+		if c.Medium == "image" || strings.HasPrefix(c.Type, "image/") {
+			if u := strings.TrimSpace(c.URL); u != "" {
+				return u
+			}
+		}
+	}
+	for _, e := range item.Enclosures {
+		if strings.HasPrefix(e.Type, "image/") {
+			if u := strings.TrimSpace(e.URL); u != "" {
+				return u
+			}
+		}
+	}
+	return strings.TrimSpace(item.ITunesImage.Href)
+}
+
+// pageImage fetches the head of a linked page and returns its og:image (or
+// twitter:image), resolved against the final URL after redirects
+func pageImage(ctx context.Context, link string) (string, error) {
+	if !strings.HasPrefix(link, "http://") && !strings.HasPrefix(link, "https://") {
+		return "", nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := pageClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", response.Status)
+	}
+	if ct := response.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return "", nil
+	}
+
+	image := metaImage(io.LimitReader(response.Body, maxPageHead))
+	if image == "" {
+		return "", nil
+	}
+	return resolveURL(response.Request.URL.String(), image), nil
+}
+
+// metaImage reads the <meta> tags of a page up to <body>, preferring
+// og:image over twitter:image
+func metaImage(r io.Reader) string {
+	var twitter string
+	z := html.NewTokenizer(r)
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return twitter
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				return twitter
+			case "meta":
+				if !hasAttr {
+					continue
+				}
+				attrs := tagAttrs(z)
+				key := attrs["property"]
+				if key == "" {
+					key = attrs["name"]
+				}
+				content := strings.TrimSpace(attrs["content"])
+				if content == "" {
+					continue
+				}
+				switch strings.ToLower(key) {
+				case "og:image", "og:image:url", "og:image:secure_url":
+					return content
+				case "twitter:image", "twitter:image:src":
+					if twitter == "" {
+						twitter = content
+					}
+				}
+			}
+		}
+	}
+}
+
+// contentImage returns the first image in article HTML that is at least
+// minLeadImageWidth x minLeadImageHeight. Declared width and height
+// attributes are trusted; images without them are measured by downloading
+// their header.
+func contentImage(ctx context.Context, content, link string) string {
+	z := html.NewTokenizer(strings.NewReader(content))
+	checked := 0
+
+	for checked < maxContentImages && ctx.Err() == nil {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "img" || !hasAttr {
+				continue
+			}
+			attrs := tagAttrs(z)
+			src := resolveURL(link, strings.TrimSpace(attrs["src"]))
+			if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+				continue
+			}
+			checked++
+
+			width, height := dimension(attrs["width"]), dimension(attrs["height"])
+			if width == 0 || height == 0 {
+				w, h, err := images.Size(ctx, src)
+				if err != nil {
+					continue
+				}
+				width, height = w, h
+			}
+			if width >= minLeadImageWidth && height >= minLeadImageHeight {
+				return src
+			}
+		}
+	}
+	return ""
+}
+
+// tagAttrs collects the attributes of the current tag
+func tagAttrs(z *html.Tokenizer) map[string]string {
+	attrs := map[string]string{}
+	for {
+		key, val, more := z.TagAttr()
+		attrs[string(key)] = string(val)
+		if !more {
+			return attrs
+		}
+	}
+}
+
+// dimension parses a width or height attribute ("640" or "640px"), returning
+// 0 for anything else (percentages, empty)
+func dimension(s string) int {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(s), "px"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// resolveURL makes ref absolute against base. ref is returned unchanged
+// when either can't be parsed.
+func resolveURL(base, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	return baseURL.ResolveReference(refURL).String()
+}
+
+// GetArticleThumbnail serves the lead image of an article scaled down to
+// ?width= pixels wide, rounded up to a thumbnail width, as a JPEG
+func GetArticleThumbnail(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	width := defaultThumbnailWidth
+	if param := r.URL.Query().Get("width"); param != "" {
+		width, err = strconv.Atoi(param)
+		if err != nil || width < 1 || width > maxThumbnailWidth {
+			http.Error(w, fmt.Sprintf("width must be between 1 and %d", maxThumbnailWidth), http.StatusBadRequest)
+			return
+		}
+	}
+
+	articles, err := db.GetSingleArticle(r.Context(), id)
+	if err != nil || len(articles) == 0 {
+		http.Error(w, fmt.Sprintf("Article %d not found", id), http.StatusNotFound)
+		return
+	}
+	if articles[0].LeadImage == "" {
+		http.Error(w, fmt.Sprintf("Article %d has no lead image", id), http.StatusNotFound)
+		return
+	}
+
+	thumbnail, err := images.ThumbnailJPEG(r.Context(), articles[0].LeadImage, snapThumbnailWidth(width))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error making thumbnail", "articleId", id, "error", err)
+		http.Error(w, "Failed to fetch lead image", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(thumbnail)
+}
@@ -0,0 +1,161 @@
+package rss
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// opml is the subscription list format other feed readers import and
+// export. Categories are written as outlines containing their feeds.
+type opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    opmlBody `xml:"body"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlBody struct {
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// OPMLImport is the outcome of importing a subscription list
+type OPMLImport struct {
+	Added    []string // Feed URLs that were subscribed to
+	Existing []string // Feed URLs that were already subscribed to
+	Failed   []OPMLFailure
+}
+
+type OPMLFailure struct {
+	URL   string
+	Error string
+}
+
+// ImportOPML subscribes to every feed in an OPML document that isn't
+// subscribed to yet, putting it in the category named by the outline it's
+// nested in. Feeds that fail to load are reported and skipped.
+func ImportOPML(ctx context.Context, r io.Reader) (*OPMLImport, error) {
+	var doc opml
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parsing OPML: %w", err)
+	}
+
+	result := &OPMLImport{}
+	var walk func(outlines []opmlOutline, category string)
+	walk = func(outlines []opmlOutline, category string) {
+		for _, o := range outlines {
+			if o.XMLURL == "" {
+				name := strings.TrimSpace(o.Text)
+				if name == "" {
+					name = strings.TrimSpace(o.Title)
+				}
+				if name == "" {
+					name = category
+				}
+				walk(o.Outlines, name)
+				continue
+			}
+			importOPMLFeed(ctx, strings.TrimSpace(o.XMLURL), category, result)
+		}
+	}
+	walk(doc.Body.Outlines, "")
+
+	return result, nil
+}
+
+func importOPMLFeed(ctx context.Context, url, category string, result *OPMLImport) {
+	fail := func(err error) {
+		slog.ErrorContext(ctx, "Error importing feed", "url", url, "error", err)
+		result.Failed = append(result.Failed, OPMLFailure{URL: url, Error: err.Error()})
+	}
+
+	existing, err := db.GetRSSByURL(ctx, url)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if existing != nil {
+		result.Existing = append(result.Existing, url)
+		return
+	}
+
+	feed, err := CreateFeed(ctx, url)
+	if err != nil {
+		fail(err)
+		return
+	}
+	result.Added = append(result.Added, url)
+
+	if category == "" {
+		return
+	}
+	if err := SetFeedCategory(ctx, feed.ID, category); err != nil {
+		slog.ErrorContext(ctx, "Error setting category of feed", "url", url, "category", category, "error", err)
+	}
+}
+
+// WriteOPML writes every subscribed feed as an OPML document, with
+// categorized feeds nested under their category
+func WriteOPML(ctx context.Context, w io.Writer) error {
+	feeds, err := db.GetAllRSS(ctx)
+	if err != nil {
+		return err
+	}
+	categories, err := db.GetAllCategories(ctx)
+	if err != nil {
+		return err
+	}
+
+	doc := opml{
+		Version: "2.0",
+		Head:    opmlHead{Title: "go-reader subscriptions", DateCreated: time.Now().UTC().Format(time.RFC1123Z)},
+	}
+
+	byCategory := map[int][]opmlOutline{}
+	for _, feed := range feeds {
+		o := opmlOutline{Text: feed.Title, Title: feed.Title, Type: "rss", XMLURL: feed.URL}
+		if o.Text == "" {
+			o.Text = feed.URL
+		}
+		if feed.CategoryID == nil {
+			doc.Body.Outlines = append(doc.Body.Outlines, o)
+			continue
+		}
+		byCategory[*feed.CategoryID] = append(byCategory[*feed.CategoryID], o)
+	}
+	for _, c := range categories {
+		if outlines := byCategory[c.ID]; len(outlines) > 0 {
+			doc.Body.Outlines = append(doc.Body.Outlines, opmlOutline{Text: c.Name, Title: c.Name, Outlines: outlines})
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
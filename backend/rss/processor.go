@@ -3,13 +3,17 @@ package rss
 import (
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/metrics"
 	"github.com/microcosm-cc/bluemonday"
 )
 
 // Content processor struct
 type ContentProcessor struct {
 	sanitizer *bluemonday.Policy
+	urlRules  *db.FeedURLRules // Canonicalize links with these rules when set
 }
 
 func NewContentProcessor() *ContentProcessor {
@@ -20,12 +24,29 @@ func NewContentProcessor() *ContentProcessor {
 	p.AllowElements("p", "br", "strong", "em", "b", "i", "u", "h1", "h2", "h3", "h4", "h5", "h6")
 	p.AllowElements("ul", "ol", "li", "blockquote", "pre", "code")
 	p.AllowElements("a").AllowAttrs("href", "title").OnElements("a")
-	p.AllowElements("img").AllowAttrs("src", "alt", "title", "width", "height").OnElements("img")
+	p.AllowElements("img").AllowAttrs("src", "srcset", "sizes", "alt", "title", "width", "height").OnElements("img")
+
+	// Additions from the sanitizer configuration
+	if len(config.ExtraElements) > 0 {
+		p.AllowElements(config.ExtraElements...)
+	}
+	if len(config.ExtraAttributes) > 0 {
+		p.AllowAttrs(config.ExtraAttributes...).Globally()
+	}
 
 	return &ContentProcessor{sanitizer: p}
 }
 
+// CanonicalizeLinks makes ProcessContent strip tracking from links in the
+// content, using a feed's URL rules
+func (cp *ContentProcessor) CanonicalizeLinks(rules *db.FeedURLRules) {
+	cp.urlRules = rules
+}
+
 func (cp *ContentProcessor) ProcessContent(rawContent string) string {
+	start := time.Now()
+	defer func() { metrics.ContentProcessed(time.Since(start)) }()
+
 	// 1. Clean HTML
 	cleaned := cp.sanitizer.Sanitize(rawContent)
 
@@ -35,6 +56,15 @@ func (cp *ContentProcessor) ProcessContent(rawContent string) string {
 	// 3. Normalize structure
 	cleaned = cp.normalizeStructure(cleaned)
 
+	// 4. Clean up links
+	if cp.urlRules != nil {
+		cleaned = canonicalizeLinks(cleaned, cp.urlRules)
+	}
+
+	// 5. Check image sources. Images keep their original URLs, they're
+	// pointed at the image proxy when articles are served.
+	cleaned = checkSrcsets(cleaned)
+
 	return cleaned
 }
 
@@ -0,0 +1,466 @@
+package rss
+
+import (
+	"crypto/subtle"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// Republishing of aggregated articles as RSS 2.0 and Atom feeds. Article
+// content is the sanitized HTML stored by ContentProcessor at ingest.
+
+const (
+	formatRSS  = "rss"
+	formatAtom = "atom"
+
+	defaultPublishLimit = 50
+	maxPublishLimit     = 200
+)
+
+// publishedFeed is the format independent content of an output feed
+type publishedFeed struct {
+	Title       string
+	Description string
+	Articles    []db.Article
+}
+
+// RSS 2.0 output
+
+type rssOutput struct {
+	XMLName      xml.Name      `xml:"rss"`
+	Version      string        `xml:"version,attr"`
+	XMLNSContent string        `xml:"xmlns:content,attr"`
+	XMLNSAtom    string        `xml:"xmlns:atom,attr"`
+	Channel      rssOutChannel `xml:"channel"`
+}
+
+type rssOutChannel struct {
+	Title         string       `xml:"title"`
+	Link          string       `xml:"link"`
+	Description   string       `xml:"description"`
+	SelfLink      rssAtomLink  `xml:"atom:link"`
+	LastBuildDate string       `xml:"lastBuildDate"`
+	Generator     string       `xml:"generator"`
+	Items         []rssOutItem `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssOutItem struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	GUID        rssOutGUID `xml:"guid"`
+	PubDate     string     `xml:"pubDate"`
+	Description string     `xml:"description"`
+	Content     string     `xml:"content:encoded"`
+	Enclosure   *rssOutEnclosure
+}
+
+// rssOutEnclosure is the item's first enclosure; RSS 2.0 allows only one
+type rssOutEnclosure struct {
+	XMLName xml.Name `xml:"enclosure"`
+	URL     string   `xml:"url,attr"`
+	Length  int64    `xml:"length,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+type rssOutGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// Atom output
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomPerson  `xml:"author"`
+	Subtitle  string      `xml:"subtitle,omitempty"`
+	Generator string      `xml:"generator"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Links     []atomLink  `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Handlers
+
+func PublishAllRSS(w http.ResponseWriter, r *http.Request)  { publishAll(w, r, formatRSS) }
+func PublishAllAtom(w http.ResponseWriter, r *http.Request) { publishAll(w, r, formatAtom) }
+
+func PublishStarredRSS(w http.ResponseWriter, r *http.Request)  { publishStarred(w, r, formatRSS) }
+func PublishStarredAtom(w http.ResponseWriter, r *http.Request) { publishStarred(w, r, formatAtom) }
+
+func PublishSearchRSS(w http.ResponseWriter, r *http.Request)  { publishSearch(w, r, formatRSS) }
+func PublishSearchAtom(w http.ResponseWriter, r *http.Request) { publishSearch(w, r, formatAtom) }
+
+// PublishCategory serves /api/feeds/category/{file}, where file is the
+// category id followed by .xml, .rss or .atom
+func PublishCategory(w http.ResponseWriter, r *http.Request) {
+	id, format, limit, ok := publishParams(w, r)
+	if !ok {
+		return
+	}
+
+	category, err := db.GetCategoryByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Category %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	articles, err := db.GetArticlesByCategory(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, "Failed to get articles", http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, r, format, publishedFeed{
+		Title:       category.Name,
+		Description: fmt.Sprintf("Articles in the %s category", category.Name),
+		Articles:    articles,
+	})
+}
+
+// PublishSource serves /api/feeds/source/{file}, the full-text version of a
+// single subscribed feed
+func PublishSource(w http.ResponseWriter, r *http.Request) {
+	id, format, limit, ok := publishParams(w, r)
+	if !ok {
+		return
+	}
+
+	feed, err := db.GetRSSByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Feed %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	articles, err := db.GetArticleByRSSID(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, "Failed to get articles", http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, r, format, publishedFeed{
+		Title:       feed.Title,
+		Description: feed.Description,
+		Articles:    articles,
+	})
+}
+
+func publishAll(w http.ResponseWriter, r *http.Request, format string) {
+	limit, ok := publishAuth(w, r)
+	if !ok {
+		return
+	}
+
+	articles, err := db.GetRecentArticles(r.Context(), limit)
+	if err != nil {
+		http.Error(w, "Failed to get articles", http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, r, format, publishedFeed{
+		Title:       "go-reader: all articles",
+		Description: "The newest articles from all feeds",
+		Articles:    articles,
+	})
+}
+
+func publishStarred(w http.ResponseWriter, r *http.Request, format string) {
+	limit, ok := publishAuth(w, r)
+	if !ok {
+		return
+	}
+
+	articles, err := db.GetStarredArticles(r.Context(), limit)
+	if err != nil {
+		http.Error(w, "Failed to get articles", http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, r, format, publishedFeed{
+		Title:       "go-reader: starred",
+		Description: "Starred articles",
+		Articles:    articles,
+	})
+}
+
+func publishSearch(w http.ResponseWriter, r *http.Request, format string) {
+	limit, ok := publishAuth(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		http.Error(w, "Query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	articles, err := db.SearchArticles(r.Context(), query, limit)
+	if err != nil {
+		http.Error(w, "Failed to search articles", http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, r, format, publishedFeed{
+		Title:       fmt.Sprintf("go-reader: %s", query),
+		Description: fmt.Sprintf("Articles matching %q", query),
+		Articles:    articles,
+	})
+}
+
+// publishAuth checks the feed token, when one is configured, and reads the
+// limit parameter. It writes the error response itself.
+func publishAuth(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if config.FeedToken != "" {
+		token := r.URL.Query().Get("token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.FeedToken)) != 1 {
+			http.Error(w, "Invalid or missing token", http.StatusUnauthorized)
+			return 0, false
+		}
+	}
+
+	limit, err := queryLimit(r, defaultPublishLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	return min(limit, maxPublishLimit), true
+}
+
+// publishParams handles the {file} wildcard ("12.xml", "12.atom") in
+// addition to publishAuth
+func publishParams(w http.ResponseWriter, r *http.Request) (id int, format string, limit int, ok bool) {
+	limit, ok = publishAuth(w, r)
+	if !ok {
+		return 0, "", 0, false
+	}
+
+	file := r.PathValue("file")
+	name, ext, found := strings.Cut(file, ".")
+	if found {
+		switch ext {
+		case "xml", "rss":
+			format = formatRSS
+		case "atom":
+			format = formatAtom
+		}
+	}
+	if format == "" {
+		http.Error(w, "Feed must end in .xml, .rss or .atom", http.StatusNotFound)
+		return 0, "", 0, false
+	}
+
+	id, err := strconv.Atoi(name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ID %q", name), http.StatusBadRequest)
+		return 0, "", 0, false
+	}
+	return id, format, limit, true
+}
+
+// Rendering
+
+func writeFeed(w http.ResponseWriter, r *http.Request, format string, feed publishedFeed) {
+	proxyArticleImages(feed.Articles)
+
+	var out any
+	contentType := "application/rss+xml; charset=utf-8"
+	if format == formatAtom {
+		out = buildAtom(r, feed)
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		out = buildRSS(r, feed)
+	}
+
+	body, err := xml.MarshalIndent(out, "", "  ")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding feed", "format", format, "error", err)
+		http.Error(w, "Failed to encode feed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func buildRSS(r *http.Request, feed publishedFeed) rssOutput {
+	self := selfURL(r)
+	channel := rssOutChannel{
+		Title:         feed.Title,
+		Link:          self,
+		Description:   feed.Description,
+		SelfLink:      rssAtomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+		LastBuildDate: lastUpdated(feed.Articles).Format(time.RFC1123Z),
+		Generator:     "go-reader",
+	}
+
+	for _, article := range feed.Articles {
+		item := rssOutItem{
+			Title:       article.Title,
+			Link:        article.Link,
+			GUID:        rssOutGUID{Value: articleID(article)},
+			PubDate:     articleDate(article).Format(time.RFC1123Z),
+			Description: article.Description,
+			Content:     article.Description,
+		}
+		if len(article.Enclosures) > 0 {
+			e := article.Enclosures[0]
+			item.Enclosure = &rssOutEnclosure{URL: e.URL, Length: e.Length, Type: e.MimeType}
+		}
+		channel.Items = append(channel.Items, item)
+	}
+
+	return rssOutput{
+		Version:      "2.0",
+		XMLNSContent: "http://purl.org/rss/1.0/modules/content/",
+		XMLNSAtom:    "http://www.w3.org/2005/Atom",
+		Channel:      channel,
+	}
+}
+
+func buildAtom(r *http.Request, feed publishedFeed) atomFeed {
+	self := selfURL(r)
+	out := atomFeed{
+		Title:     feed.Title,
+		ID:        self,
+		Updated:   lastUpdated(feed.Articles).Format(time.RFC3339),
+		Links:     []atomLink{{Href: self, Rel: "self", Type: "application/atom+xml"}},
+		Author:    atomPerson{Name: "go-reader"},
+		Subtitle:  feed.Description,
+		Generator: "go-reader",
+	}
+
+	for _, article := range feed.Articles {
+		date := articleDate(article).Format(time.RFC3339)
+		links := []atomLink{{Href: article.Link, Rel: "alternate", Type: "text/html"}}
+		for _, e := range article.Enclosures {
+			links = append(links, atomLink{Href: e.URL, Rel: "enclosure", Type: e.MimeType, Length: e.Length})
+		}
+		out.Entries = append(out.Entries, atomEntry{
+			Title:     article.Title,
+			ID:        atomID(article),
+			Links:     links,
+			Published: date,
+			Updated:   date,
+			Content:   atomContent{Type: "html", Value: article.Description},
+		})
+	}
+
+	return out
+}
+
+// selfURL reconstructs the public URL of the request, without the token
+func selfURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	query := r.URL.Query()
+	query.Del("token")
+
+	u := fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
+	if encoded := query.Encode(); encoded != "" {
+		u += "?" + encoded
+	}
+	return u
+}
+
+// articleID returns a stable unique id for an article, preferring the
+// publisher's GUID
+func articleID(article db.Article) string {
+	if article.GUID != "" {
+		return article.GUID
+	}
+	if article.Link != "" {
+		return article.Link
+	}
+	return fmt.Sprintf("urn:go-reader:article:%d", article.ID)
+}
+
+// atomID returns articleID if it is usable as an Atom id (an absolute IRI)
+func atomID(article db.Article) string {
+	id := articleID(article)
+	if u, err := url.Parse(id); err == nil && u.Scheme != "" {
+		return id
+	}
+	return fmt.Sprintf("urn:go-reader:article:%d", article.ID)
+}
+
+// publishDateLayouts are the date formats seen in feed pubDate elements
+var publishDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+}
+
+// articleDate parses the publisher's date, falling back to when the article
+// was stored
+func articleDate(article db.Article) time.Time {
+	for _, layout := range publishDateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(article.PublishDate)); err == nil {
+			return t
+		}
+	}
+	return article.CreatedAt
+}
+
+func lastUpdated(articles []db.Article) time.Time {
+	var latest time.Time
+	for _, article := range articles {
+		if date := articleDate(article); date.After(latest) {
+			latest = date
+		}
+	}
+	if latest.IsZero() {
+		return time.Now()
+	}
+	return latest
+}
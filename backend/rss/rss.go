@@ -2,29 +2,47 @@ package rss
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/dedupe"
+	"github.com/JonSchaeffer/go-reader/events"
+	"github.com/JonSchaeffer/go-reader/logging"
+	"github.com/JonSchaeffer/go-reader/metrics"
+	"github.com/JonSchaeffer/go-reader/rules"
+	"github.com/JonSchaeffer/go-reader/webhooks"
 )
 
 // Global config variable
 var config Config
 
 type Config struct {
-	FiveFiltersURL string
+	FiveFiltersURL      string
+	FiveFiltersMaxItems int           // Items FiveFilters extracts per fetch
+	FetchInterval       time.Duration // Time between fetches of all feeds
+	FeedToken           string        // Required ?token= on republished feeds when set
+	KeepRevisions       bool          // Keep the previous version when an article is edited
+	ExtraElements       []string      // HTML elements allowed in content on top of the default policy
+	ExtraAttributes     []string      // HTML attributes allowed on every element
+	FetchHistoryDays    int           // Days of fetch attempts kept per feed
 }
 
 // SetConfig sets the global configuration for the RSS package
 func SetConfig(cfg *Config) {
 	if cfg != nil {
-		config.FiveFiltersURL = cfg.FiveFiltersURL
+		config = *cfg
 	}
 }
 
@@ -57,10 +75,71 @@ type Item struct {
 	PubDate     string `xml:"pubDate"`
 	Format      string `xml:"format"`
 	Identifier  string `xml:"identifier"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	OrigLink    string `xml:"http://rssnamespace.org/feedburner/ext/1.0 origLink"`
+
+	// Full body, when the feed has one besides the description
+	ContentEncoded string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Categories     []string `xml:"category"`
+
+	// Media
+	Enclosures      []itemEnclosure  `xml:"enclosure"`
+	MediaContents   []mediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaGroups     []mediaGroup     `xml:"http://search.yahoo.com/mrss/ group"`
+	MediaThumbnails []mediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	ITunesDuration  string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	ITunesImage     itunesImage      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+}
+
+// link returns the item's link, preferring FeedBurner's original link over
+// its redirect
+func (item *Item) link() string {
+	if item.OrigLink != "" {
+		return item.OrigLink
+	}
+	return item.Link
+}
+
+// contentHash identifies the version of an item as published, before any
+// processing, so unchanged items can be skipped cheaply
+func (item *Item) contentHash() string {
+	sum := sha256.Sum256([]byte(item.Title + "\x00" + item.content()))
+	return hex.EncodeToString(sum[:])
+}
+
+// author returns dc:creator, falling back to the RSS author element
+func (item *Item) author() string {
+	if creator := strings.TrimSpace(item.Creator); creator != "" {
+		return creator
+	}
+	return strings.TrimSpace(item.Author)
+}
+
+// content returns the item's body, preferring content:encoded over the
+// description, which is often only a summary
+func (item *Item) content() string {
+	if strings.TrimSpace(item.ContentEncoded) != "" {
+		return item.ContentEncoded
+	}
+	return item.Description
+}
+
+// categories returns the item's category names, trimmed and without
+// duplicates
+func (item *Item) categories() []string {
+	categories := []string{}
+	for _, category := range item.Categories {
+		category = strings.TrimSpace(category)
+		if category != "" && !slices.Contains(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+	return categories
 }
 
 func GetRss(w http.ResponseWriter, r *http.Request) {
-	rss, err := db.GetAllRSS()
+	rss, err := db.GetAllRSS(r.Context())
 	if err != nil {
 		http.Error(w, "No data returned", http.StatusBadRequest)
 		return
@@ -76,7 +155,7 @@ func GetRss(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		rss, err := db.GetRSSByID(id)
+		rss, err := db.GetRSSByID(r.Context(), id)
 		if err != nil {
 			http.Error(w, "Invalid ID parameter", http.StatusBadRequest)
 		}
@@ -92,11 +171,12 @@ func GetRss(w http.ResponseWriter, r *http.Request) {
 
 func GetAllArticles(w http.ResponseWriter, r *http.Request) {
 	// Get article from database
-	article, err := db.GetAllArticles()
+	article, err := db.GetAllArticles(r.Context())
 	if err != nil {
 		http.Error(w, "Article not found", http.StatusNotFound)
 		return
 	}
+	proxyArticleImages(article)
 
 	// Return article as JSON
 	w.Header().Set("Content-Type", "application/json")
@@ -133,11 +213,12 @@ func GetArticlesByRSSID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get article from database
-	article, err := db.GetArticleByRSSID(id, limit)
+	article, err := db.GetArticleByRSSID(r.Context(), id, limit)
 	if err != nil {
 		http.Error(w, "Article not found", http.StatusNotFound)
 		return
 	}
+	proxyArticleImages(article)
 
 	// Return article as JSON
 	w.Header().Set("Content-Type", "application/json")
@@ -163,11 +244,12 @@ func GetSingleArticle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get article from database
-	article, err := db.GetSingleArticle(id)
+	article, err := db.GetSingleArticle(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Article not found", http.StatusNotFound)
 		return
 	}
+	proxyArticleImages(article)
 
 	// Return article as JSON
 	w.Header().Set("Content-Type", "application/json")
@@ -197,11 +279,12 @@ func SearchArticles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	article, err := db.SearchArticles(queryParam, limit)
+	article, err := db.SearchArticles(r.Context(), queryParam, limit)
 	if err != nil {
 		http.Error(w, "No search results found", http.StatusNotFound)
 		return
 	}
+	proxyArticleImages(article)
 	// Return article as JSON
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(article); err != nil {
@@ -237,11 +320,12 @@ func UpdateArticleReadStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.UpdateArticleReadStatus(id, read)
+	err = db.UpdateArticleReadStatus(r.Context(), id, read)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error updating read status for article %d", id), http.StatusBadRequest)
 		return
 	}
+	events.Publish(r.Context(), events.ArticleRead, map[string]any{"id": id, "read": read})
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf("Article %d read status set to %t", id, read)))
@@ -264,43 +348,13 @@ func PostRss(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fiveURL := GetRSSFiveURL(requestData.URL)
-
-	fiveResponse, err := http.Get(fiveURL)
+	rss, err := CreateFeed(r.Context(), requestData.URL)
 	if err != nil {
-		log.Printf("Error fetching RSS feed: %v", err)
-		http.Error(w, "Failed to fetch RSS feed", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error adding RSS feed", "url", requestData.URL, "error", err)
+		http.Error(w, "Failed to add RSS feed", http.StatusInternalServerError)
 		return
 	}
 
-	body, err := io.ReadAll(fiveResponse.Body)
-	if err != nil {
-		log.Printf("Error reading RSS response: %v", err)
-		http.Error(w, "Failed to read RSS feed", http.StatusInternalServerError)
-		return
-	}
-
-	if fiveResponse.Body != nil {
-		defer fiveResponse.Body.Close()
-	}
-
-	var rssURL RSS
-	err = xml.Unmarshal(body, &rssURL)
-	if err != nil {
-		log.Printf("Error parsing RSS XML: %v", err)
-		http.Error(w, "Failed to parse RSS feed", http.StatusInternalServerError)
-		return
-	}
-
-	// Create DB Entry
-	rss, err := db.CreateRSS(requestData.URL, fiveURL, rssURL.Channel.Title, rssURL.Channel.Description, 1, 1)
-	if err != nil {
-		log.Printf("Error creating RSS: %v", err)
-	}
-
-	// Get RSS Feed, and save it to the DB
-	SaveRSSArticles(rss.FiveURL, rss.ID)
-
 	// Return Success Response
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]interface{}{
@@ -328,7 +382,7 @@ func DeleteRSSbyID(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = db.DeleteRSSByID(id)
+		err = db.DeleteRSSByID(r.Context(), id)
 		if err != nil {
 			http.Error(w, "Invalid ID parameter", http.StatusBadRequest)
 			return
@@ -340,103 +394,291 @@ func DeleteRSSbyID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// CreateFeed fetches the feed through FiveFilters, stores it and performs the
+// initial article import.
+func CreateFeed(ctx context.Context, url string) (*db.RSS, error) {
+	fiveURL := GetRSSFiveURL(url)
+
+	fiveResponse, err := get(ctx, fiveURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching RSS feed: %w", err)
+	}
+	defer fiveResponse.Body.Close()
+
+	body, err := io.ReadAll(fiveResponse.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading RSS response: %w", err)
+	}
+
+	var rssURL RSS
+	err = xml.Unmarshal(body, &rssURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing RSS XML: %w", err)
+	}
+
+	// Create DB Entry
+	rss, err := db.CreateRSS(ctx, url, fiveURL, rssURL.Channel.Title, rssURL.Channel.Description, 1, 1)
+	if err != nil {
+		return nil, fmt.Errorf("creating RSS: %w", err)
+	}
+	if rss == nil {
+		return nil, fmt.Errorf("RSS feed %s already exists", url)
+	}
+
+	// Get RSS Feed, and save it to the DB
+	SaveRSSArticles(ctx, rss.FiveURL, rss.ID)
+
+	return rss, nil
+}
+
+// SetFeedCategory puts a feed in the category with the given name, creating
+// the category if it doesn't exist
+func SetFeedCategory(ctx context.Context, feedID int, name string) error {
+	category, err := db.GetCategoryByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if category == nil {
+		category, err = db.CreateCategory(ctx, name, "#3b82f6")
+		if err != nil {
+			return err
+		}
+	}
+	return db.UpdateRSSCategoryID(ctx, feedID, &category.ID)
+}
+
+// GetRSSFiveURL is the FiveFilters URL a feed is fetched through. Fetches
+// build it from the feed URL, so configuration changes apply to existing
+// feeds.
 func GetRSSFiveURL(RSSUrl string) string {
-	return fmt.Sprintf("%s/makefulltextfeed.php?url=%s&max=4&links=preserve", config.FiveFiltersURL, RSSUrl)
+	return fmt.Sprintf("%s/makefulltextfeed.php?url=%s&max=%d&links=preserve", config.FiveFiltersURL, RSSUrl, config.FiveFiltersMaxItems)
 }
 
-func SaveRSSArticles(FeedURL string, FeedID int) {
-	response, err := http.Get(FeedURL)
+// get fetches a URL, giving up when ctx is done
+func get(ctx context.Context, url string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Printf("Error fetching feed URL %s: %v", FeedURL, err)
-		return
+		return nil, err
 	}
+	return http.DefaultClient.Do(request)
+}
 
-	body, err := io.ReadAll(response.Body)
+// SaveRSSArticles fetches a feed and stores any new articles. Fetch and parse
+// failures are returned and also reported as feed.failed/feed.recovered events.
+// Everything logged during the fetch carries the feed's ID and URL, and the
+// attempt is added to the feed's fetch history.
+func SaveRSSArticles(ctx context.Context, FeedURL string, FeedID int) error {
+	ctx = logging.With(ctx, "feedId", FeedID, "feedUrl", FeedURL)
+	fetch := &db.FetchLog{RssID: FeedID, StartedAt: time.Now()}
+	err := saveRSSArticles(ctx, FeedURL, FeedID, fetch)
+	duration := time.Since(fetch.StartedAt)
+	if err == nil {
+		slog.InfoContext(ctx, "Fetched feed", "items", fetch.ItemsSeen, "inserted", fetch.ItemsInserted,
+			"duration", duration.String())
+	}
+
+	metrics.FeedFetched(FeedID, fetchOutcome(ctx, err), duration)
+	recordFetch(ctx, fetch, duration, err)
+	recordFeedResult(ctx, FeedID, err)
+	return err
+}
+
+// fetchOutcome classifies a fetch's error for the fetch metrics
+func fetchOutcome(ctx context.Context, err error) string {
+	var syntaxErr *xml.SyntaxError
+	var unmarshalErr xml.UnmarshalError
+	switch {
+	case err == nil:
+		return metrics.FetchSuccess
+	case ctx.Err() != nil:
+		return metrics.FetchCancelled
+	case errors.As(err, &syntaxErr), errors.As(err, &unmarshalErr):
+		return metrics.FetchParseError
+	default:
+		return metrics.FetchError
+	}
+}
+
+// saveRSSArticles does the work of SaveRSSArticles, filling in the response
+// and item counts of fetch as it goes
+func saveRSSArticles(ctx context.Context, FeedURL string, FeedID int, fetch *db.FetchLog) error {
+	response, err := get(ctx, FeedURL)
 	if err != nil {
-		log.Printf("Error reading feed response from %s: %v", FeedURL, err)
-		return
+		slog.ErrorContext(ctx, "Error fetching feed", "error", err)
+		return err
 	}
+	defer response.Body.Close()
 
-	if response.Body != nil {
-		defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	metrics.FeedResponse(FeedID, response.StatusCode, len(body))
+	fetch.StatusCode = &response.StatusCode
+	fetch.Bytes = int64(len(body))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading feed response", "status", response.StatusCode, "error", err)
+		return err
 	}
 
 	var rss RSS
 	err = xml.Unmarshal(body, &rss)
 	if err != nil {
-		log.Printf("Error parsing RSS XML from %s: %v", FeedURL, err)
-		return
+		slog.ErrorContext(ctx, "Error parsing feed XML", "status", response.StatusCode, "bytes", len(body), "error", err)
+		return err
 	}
 
+	fetch.ItemsSeen = len(rss.Channel.Items)
 	processor := NewContentProcessor()
 
+	urlRules, err := db.GetFeedURLRules(ctx, FeedID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading URL rules", "error", err)
+		urlRules = &db.FeedURLRules{}
+	}
+	processor.CanonicalizeLinks(urlRules)
+
+	feedRules, err := db.GetRulesForFeed(ctx, FeedID)
+	if err != nil {
+		// Keep ingesting without rules rather than dropping the whole fetch
+		slog.ErrorContext(ctx, "Error loading feed rules", "error", err)
+	}
+
 	for _, item := range rss.Channel.Items {
-		// TODO: This approach isn't super efficient. It will process the description
-		// for every Rss Article even if it already exists. I think putting in a Check
-		// to compare GUID's first should help with this.
+		// Stop between items when shutting down, the rest are picked up by
+		// the next fetch
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Items already stored are matched by GUID (or normalized link) and
+		// only processed again when their content changed
+		link := CanonicalURL(item.link(), urlRules)
+		hash := item.contentHash()
+		stored, err := db.FindStoredItem(ctx, FeedID, item.GUID, dedupe.URLKey(link), link)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error looking up stored article", "title", item.Title, "error", err)
+			metrics.FeedItem(FeedID, metrics.ItemFailed)
+			continue
+		}
+		if stored != nil {
+			metrics.FeedItem(FeedID, updateStoredItem(ctx, stored, item, link, hash, processor, feedRules))
+			continue
+		}
 
 		// Process description
-		processedDescription := processor.ProcessContent(item.Description)
+		processedDescription := processor.ProcessContent(item.content())
+
+		// Rules run before the insert so skipped items are never stored
+		result := rules.Evaluate(feedRules, rules.Candidate{
+			Title:   item.Title,
+			Content: processedDescription,
+			Author:  item.author(),
+			Link:    link,
+		})
+		if result.Skip {
+			metrics.FeedItem(FeedID, metrics.ItemSkipped)
+			continue
+		}
 
-		_, err := db.CreateArticle(FeedID, item.Title, item.Link,
+		article, err := db.CreateArticle(ctx, FeedID, item.Title, link,
 			item.GUID, processedDescription, item.PubDate,
-			item.Format, item.Identifier, false)
+			item.Format, item.Identifier, item.author(), item.categories(), result.MarkRead, hash)
 		if err != nil {
-			log.Printf("Error saving article '%s': %v", item.Title, err)
+			slog.ErrorContext(ctx, "Error saving article", "title", item.Title, "error", err)
+			metrics.FeedItem(FeedID, metrics.ItemFailed)
+			continue
+		}
+		if article == nil {
+			// Same link as a stored article with a different GUID
+			metrics.FeedItem(FeedID, metrics.ItemDuplicate)
 			continue
 		}
-		fmt.Printf("%+v saved successfully.\n", item.Title)
+		slog.DebugContext(ctx, "Saved article", "articleId", article.ID, "title", item.Title)
+		metrics.FeedItem(FeedID, metrics.ItemInserted)
+		fetch.ItemsInserted++
+
+		if enclosures := item.enclosures(); len(enclosures) > 0 {
+			if err := db.AddEnclosures(ctx, article.ID, enclosures); err != nil {
+				slog.ErrorContext(ctx, "Error saving enclosures", "articleId", article.ID, "error", err)
+			} else if stored, err := db.GetSingleArticle(ctx, article.ID); err == nil && len(stored) > 0 {
+				article.Enclosures = stored[0].Enclosures
+			}
+		}
+
+		setLeadImage(ctx, article, item, processedDescription, link)
+
+		clusterArticle(ctx, article)
+		applyRuleResult(ctx, article, result)
+
+		events.Publish(ctx, events.ArticleCreated, map[string]any{
+			"id":          article.ID,
+			"rssId":       article.RssID,
+			"title":       article.Title,
+			"link":        article.Link,
+			"publishDate": article.PublishDate,
+		})
+		webhooks.ArticleCreated(ctx, article)
 	}
+
+	return nil
 }
 
 func StartRSSFetcher(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Minute)
+	ticker := time.NewTicker(config.FetchInterval)
 	defer ticker.Stop()
 
-	log.Println("RSS fetcher started")
+	fetcherRunning(true)
+	defer fetcherRunning(false)
+
+	slog.InfoContext(ctx, "RSS fetcher started", "interval", config.FetchInterval.String())
 
 	// Run once immediately
-	FetchNewArticles()
+	FetchNewArticles(ctx)
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("RSS fetcher stopping...")
+			slog.InfoContext(ctx, "RSS fetcher stopping")
 			return
 		case <-ticker.C:
-			log.Println("Starting scheduled RSS fetch...")
-			FetchNewArticles()
+			FetchNewArticles(ctx)
 		}
 	}
 }
 
-func FetchNewArticles() {
-	log.Println("Starting to fetch new articles...")
+func FetchNewArticles(ctx context.Context) {
+	recordCycle(true)
+	defer recordCycle(false)
+	start := time.Now()
+	defer func() { metrics.FetchCycle(time.Since(start)) }()
 
-	rss, err := db.GetAllRSS()
+	rss, err := db.GetAllRSS(ctx)
 	if err != nil {
-		log.Printf("ERROR: Failed to get RSS feeds: %v", err)
-		return // Changed from log.Fatal to return
+		slog.ErrorContext(ctx, "Error loading feeds to fetch", "error", err)
+		return
 	}
 
-	log.Printf("Processing %d RSS feeds", len(rss))
+	slog.InfoContext(ctx, "Fetching feeds", "feeds", len(rss))
 
 	for i, item := range rss {
-		log.Printf("Processing feed %d/%d: %s", i+1, len(rss), item.FiveURL)
+		if ctx.Err() != nil {
+			slog.InfoContext(ctx, "Stopped fetching feeds", "fetched", i, "feeds", len(rss))
+			return
+		}
+		fetchURL := GetRSSFiveURL(item.URL)
+		slog.DebugContext(ctx, "Fetching feed", "feedId", item.ID, "feedUrl", fetchURL, "position", i+1, "feeds", len(rss))
 
 		// Wrap in a function to catch panics
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
-					log.Printf("Recovered from panic processing RSS feed %s: %v", item.FiveURL, r)
+					slog.ErrorContext(ctx, "Recovered from panic fetching feed", "feedId", item.ID, "feedUrl", fetchURL, "panic", r)
 				}
 			}()
 
-			SaveRSSArticles(item.FiveURL, item.ID)
+			SaveRSSArticles(ctx, fetchURL, item.ID)
 		}()
 	}
 
-	log.Println("Finished fetching articles")
+	slog.InfoContext(ctx, "Finished fetching feeds", "feeds", len(rss), "duration", time.Since(start).String())
 }
 
 func UpdateRSS(w http.ResponseWriter, r *http.Request) {
@@ -467,7 +709,7 @@ func UpdateRSS(w http.ResponseWriter, r *http.Request) {
 	updatedValues := map[string]interface{}{}
 
 	if urlParam != "" {
-		err := db.UpdateRSS(id, "url", urlParam)
+		err := db.UpdateRSS(r.Context(), id, "url", urlParam)
 		if err != nil {
 			http.Error(w, "Error updating RSS URL", http.StatusBadRequest)
 			return
@@ -482,7 +724,7 @@ func UpdateRSS(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid feed size parameter", http.StatusBadRequest)
 			return
 		}
-		err = db.UpdateRSS(id, "feedsize", feedSize)
+		err = db.UpdateRSS(r.Context(), id, "feedsize", feedSize)
 		if err != nil {
 			http.Error(w, "Error updating RSS feed size", http.StatusBadRequest)
 			return
@@ -497,7 +739,7 @@ func UpdateRSS(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid sync parameter", http.StatusBadRequest)
 			return
 		}
-		err = db.UpdateRSS(id, "sync", sync)
+		err = db.UpdateRSS(r.Context(), id, "sync", sync)
 		if err != nil {
 			http.Error(w, "Error updating RSS feed sync", http.StatusBadRequest)
 			return
@@ -509,7 +751,7 @@ func UpdateRSS(w http.ResponseWriter, r *http.Request) {
 	if categoryIDParam != "" {
 		if categoryIDParam == "null" {
 			// Set to NULL for uncategorized
-			err = db.UpdateRSSCategoryID(id, nil)
+			err = db.UpdateRSSCategoryID(r.Context(), id, nil)
 			if err != nil {
 				http.Error(w, "Error updating RSS feed category", http.StatusBadRequest)
 				return
@@ -522,7 +764,7 @@ func UpdateRSS(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "Invalid category ID parameter", http.StatusBadRequest)
 				return
 			}
-			err = db.UpdateRSSCategoryID(id, &categoryID)
+			err = db.UpdateRSSCategoryID(r.Context(), id, &categoryID)
 			if err != nil {
 				http.Error(w, "Error updating RSS feed category", http.StatusBadRequest)
 				return
@@ -559,7 +801,7 @@ func GetRSSStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get stats from database
-	stats, err := db.GetRSSStats(id)
+	stats, err := db.GetRSSStats(r.Context(), id)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving stats for RSS feed %d: %v", id, err), http.StatusNotFound)
 		return
@@ -573,6 +815,47 @@ func GetRSSStats(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// maxFetchLogLimit caps the fetches returned by one GetFeedFetches request
+const maxFetchLogLimit = 500
+
+// GetFeedFetches lists a feed's recorded fetch attempts, newest first.
+// ?before= (RFC 3339) pages back to fetches that started before that time.
+func GetFeedFetches(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryLimit(r, 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, maxFetchLogLimit)
+
+	var before time.Time
+	if beforeParam := r.URL.Query().Get("before"); beforeParam != "" {
+		before, err = time.Parse(time.RFC3339, beforeParam)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid before %q, expected a time like 2024-01-02T15:04:05Z", beforeParam), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := db.GetRSSByID(r.Context(), id); err != nil {
+		http.Error(w, fmt.Sprintf("RSS feed %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	fetches, err := db.GetFetchLog(r.Context(), id, before, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading fetch history", "feedId", id, "error", err)
+		http.Error(w, "Failed to load fetch history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, fetches)
+}
+
 func DeleteArticle(w http.ResponseWriter, r *http.Request) {
 	idParam := r.URL.Query().Get("id")
 
@@ -589,7 +872,7 @@ func DeleteArticle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete article from database
-	err = db.DeleteArticle(id)
+	err = db.DeleteArticle(r.Context(), id)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error deleting article %d: %v", id, err), http.StatusNotFound)
 		return
@@ -602,7 +885,7 @@ func DeleteArticle(w http.ResponseWriter, r *http.Request) {
 // Category management handlers
 
 func GetCategories(w http.ResponseWriter, r *http.Request) {
-	categories, err := db.GetAllCategories()
+	categories, err := db.GetAllCategories(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to get categories", http.StatusInternalServerError)
 		return
@@ -634,7 +917,7 @@ func PostCategory(w http.ResponseWriter, r *http.Request) {
 		reqData.Color = "#3b82f6"
 	}
 
-	category, err := db.CreateCategory(reqData.Name, reqData.Color)
+	category, err := db.CreateCategory(r.Context(), reqData.Name, reqData.Color)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create category: %v", err), http.StatusBadRequest)
 		return
@@ -678,7 +961,7 @@ func UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		reqData.Color = "#3b82f6"
 	}
 
-	err = db.UpdateCategory(id, reqData.Name, reqData.Color)
+	err = db.UpdateCategory(r.Context(), id, reqData.Name, reqData.Color)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to update category: %v", err), http.StatusBadRequest)
 		return
@@ -707,7 +990,7 @@ func DeleteCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.DeleteCategoryByID(id)
+	err = db.DeleteCategoryByID(r.Context(), id)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to delete category: %v", err), http.StatusNotFound)
 		return
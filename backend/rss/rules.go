@@ -0,0 +1,303 @@
+package rss
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/rules"
+	"github.com/JonSchaeffer/go-reader/webhooks"
+)
+
+// applyRuleResult carries out the rule actions that need the stored article.
+// Skip and mark read are handled before the insert.
+func applyRuleResult(ctx context.Context, article *db.Article, result rules.Result) {
+	if result.Star {
+		if err := db.UpdateArticleStarredStatus(ctx, article.ID, true); err != nil {
+			slog.ErrorContext(ctx, "Error starring article from rule", "articleId", article.ID, "error", err)
+		} else {
+			article.Starred = true
+		}
+	}
+
+	for _, name := range result.Tags {
+		if _, err := db.AddArticleTag(ctx, article.ID, name); err != nil {
+			slog.ErrorContext(ctx, "Error tagging article from rule", "articleId", article.ID, "tag", name, "error", err)
+			continue
+		}
+		if !slices.Contains(article.Tags, name) {
+			article.Tags = append(article.Tags, name)
+		}
+	}
+
+	for _, webhookID := range result.Webhooks {
+		if err := webhooks.Trigger(ctx, webhookID, article); err != nil {
+			slog.ErrorContext(ctx, "Error triggering webhook from rule", "webhookId", webhookID, "articleId", article.ID, "error", err)
+		}
+	}
+
+	if result.Archive {
+		queueArchive(ctx, article.ID)
+	}
+}
+
+// Rule handlers (v2 API)
+
+// ruleBody is the body of POST and PATCH /api/v2/rules. For PATCH, omitted
+// fields keep their current value; rssId, categoryId and webhookId may be
+// null.
+type ruleBody struct {
+	Name       *string         `json:"name"`
+	RssID      json.RawMessage `json:"rssId"`
+	CategoryID json.RawMessage `json:"categoryId"`
+	Field      *string         `json:"field"`
+	MatchType  *string         `json:"matchType"`
+	Pattern    *string         `json:"pattern"`
+	Action     *string         `json:"action"`
+	WebhookID  json.RawMessage `json:"webhookId"`
+	Tag        *string         `json:"tag"`
+	Enabled    *bool           `json:"enabled"`
+	Position   *int            `json:"position"`
+}
+
+// apply copies the fields present in the body onto rule and validates it
+func (b *ruleBody) apply(rule *db.Rule) error {
+	if b.Name != nil {
+		rule.Name = *b.Name
+	}
+	if len(b.RssID) > 0 {
+		if err := json.Unmarshal(b.RssID, &rule.RssID); err != nil {
+			return fmt.Errorf("invalid rssId")
+		}
+	}
+	if len(b.CategoryID) > 0 {
+		if err := json.Unmarshal(b.CategoryID, &rule.CategoryID); err != nil {
+			return fmt.Errorf("invalid categoryId")
+		}
+	}
+	if b.Field != nil {
+		rule.Field = *b.Field
+	}
+	if b.MatchType != nil {
+		rule.MatchType = *b.MatchType
+	}
+	if b.Pattern != nil {
+		rule.Pattern = *b.Pattern
+	}
+	if b.Action != nil {
+		rule.Action = *b.Action
+	}
+	if len(b.WebhookID) > 0 {
+		if err := json.Unmarshal(b.WebhookID, &rule.WebhookID); err != nil {
+			return fmt.Errorf("invalid webhookId")
+		}
+	}
+	if b.Tag != nil {
+		rule.Tag = *b.Tag
+	}
+	if b.Enabled != nil {
+		rule.Enabled = *b.Enabled
+	}
+	if b.Position != nil {
+		rule.Position = *b.Position
+	}
+	return rules.Validate(rule)
+}
+
+// newRule returns the defaults for a rule created through the API
+func newRule() db.Rule {
+	return db.Rule{
+		Field:     rules.FieldAny,
+		MatchType: rules.MatchKeyword,
+		Enabled:   true,
+	}
+}
+
+func ListRules(w http.ResponseWriter, r *http.Request) {
+	allRules, err := db.GetAllRules(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get rules", http.StatusInternalServerError)
+		return
+	}
+	if allRules == nil {
+		allRules = []db.Rule{}
+	}
+	writeJSON(w, http.StatusOK, allRules)
+}
+
+func CreateRule(w http.ResponseWriter, r *http.Request) {
+	var reqData ruleBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	rule := newRule()
+	if err := reqData.apply(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := db.CreateRule(r.Context(), &rule)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create rule: %v", err), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func GetRule(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule, err := db.GetRuleByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Rule %d not found", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func UpdateRule(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var patch ruleBody
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := db.GetRuleByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Rule %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	if err := patch.apply(rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.UpdateRule(r.Context(), rule); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update rule: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	rule, err = db.GetRuleByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load updated rule", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func DeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteRule(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ruleTestMatch is one article matched while testing a rule
+type ruleTestMatch struct {
+	ID    int
+	RssID int
+	Title string
+	Link  string
+}
+
+type ruleTestResult struct {
+	Rule    db.Rule
+	Action  string
+	Tested  int
+	Matched []ruleTestMatch
+}
+
+// TestRule evaluates an unsaved rule from the request body against the last
+// ?limit= articles in its scope, without applying any actions
+func TestRule(w http.ResponseWriter, r *http.Request) {
+	var reqData ruleBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	// Actions aren't applied when testing, so any valid one will do
+	rule := newRule()
+	rule.Action = rules.ActionSkip
+	if err := reqData.apply(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	testRule(w, r, rule)
+}
+
+// TestSavedRule is TestRule for an existing rule
+func TestSavedRule(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule, err := db.GetRuleByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Rule %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	testRule(w, r, *rule)
+}
+
+func testRule(w http.ResponseWriter, r *http.Request, rule db.Rule) {
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, 1000)
+
+	articles, err := db.GetRecentArticlesInScope(r.Context(), rule.RssID, rule.CategoryID, limit)
+	if err != nil {
+		http.Error(w, "Failed to get articles", http.StatusInternalServerError)
+		return
+	}
+
+	result := ruleTestResult{Rule: rule, Action: rule.Action, Tested: len(articles), Matched: []ruleTestMatch{}}
+	for _, article := range articles {
+		if rules.Matches(&rule, rules.Candidate{
+			Title:   article.Title,
+			Content: article.Description,
+			Author:  article.Author,
+			Link:    article.Link,
+		}) {
+			result.Matched = append(result.Matched, ruleTestMatch{
+				ID:    article.ID,
+				RssID: article.RssID,
+				Title: article.Title,
+				Link:  article.Link,
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
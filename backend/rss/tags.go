@@ -0,0 +1,154 @@
+package rss
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/rules"
+)
+
+// Article tag handlers (v2 API)
+
+func ListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := db.GetAllTags(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get tags", http.StatusInternalServerError)
+		return
+	}
+	if tags == nil {
+		tags = []db.Tag{}
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func GetTag(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tag, err := db.GetTagByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Tag %d not found", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+// DeleteTag removes a tag from every article that carries it
+func DeleteTag(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteTag(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ListTagArticles(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.GetTagByID(r.Context(), id); err != nil {
+		http.Error(w, fmt.Sprintf("Tag %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	articles, err := db.GetArticlesByTag(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, "Failed to get articles", http.StatusInternalServerError)
+		return
+	}
+	if articles == nil {
+		articles = []db.Article{}
+	}
+	if wantsCollapse(r) {
+		if articles, err = collapseClusters(r.Context(), articles); err != nil {
+			http.Error(w, "Failed to collapse duplicates", http.StatusInternalServerError)
+			return
+		}
+	}
+	proxyArticleImages(articles)
+	writeJSON(w, http.StatusOK, articles)
+}
+
+// AddArticleTag tags an article by name, creating the tag if needed, and
+// returns the updated article
+func AddArticleTag(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var reqData struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	name := rules.NormalizeTag(reqData.Name)
+	if name == "" {
+		http.Error(w, "Tag name is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := getArticle(w, r, id); !ok {
+		return
+	}
+
+	if _, err := db.AddArticleTag(r.Context(), id, name); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to tag article %d: %v", id, err), http.StatusInternalServerError)
+		return
+	}
+
+	article, ok := getArticle(w, r, id)
+	if !ok {
+		return
+	}
+	proxyArticle(article)
+	writeJSON(w, http.StatusOK, article)
+}
+
+func RemoveArticleTag(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tagID, err := strconv.Atoi(r.PathValue("tagId"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid tag ID %q", r.PathValue("tagId")), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.RemoveArticleTag(r.Context(), id, tagID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	article, ok := getArticle(w, r, id)
+	if !ok {
+		return
+	}
+	proxyArticle(article)
+	writeJSON(w, http.StatusOK, article)
+}
@@ -0,0 +1,230 @@
+package rss
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/JonSchaeffer/go-reader/db"
+	"github.com/JonSchaeffer/go-reader/webhooks"
+)
+
+// Webhook subscription handlers (v2 API)
+
+// webhookBody is the body of POST and PATCH /api/v2/webhooks. For PATCH,
+// omitted fields keep their current value; rssId and categoryId may be null
+// to remove that part of the scope.
+type webhookBody struct {
+	URL        *string         `json:"url"`
+	Secret     *string         `json:"secret"`
+	RssID      json.RawMessage `json:"rssId"`
+	CategoryID json.RawMessage `json:"categoryId"`
+	Keyword    *string         `json:"keyword"`
+	Active     *bool           `json:"active"`
+}
+
+// apply copies the fields present in the body onto webhook
+func (b *webhookBody) apply(webhook *db.Webhook) error {
+	if b.URL != nil {
+		u, err := url.Parse(*b.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an absolute http(s) URL")
+		}
+		webhook.URL = *b.URL
+	}
+	if b.Secret != nil {
+		webhook.Secret = *b.Secret
+	}
+	if len(b.RssID) > 0 {
+		if err := json.Unmarshal(b.RssID, &webhook.RssID); err != nil {
+			return fmt.Errorf("invalid rssId")
+		}
+	}
+	if len(b.CategoryID) > 0 {
+		if err := json.Unmarshal(b.CategoryID, &webhook.CategoryID); err != nil {
+			return fmt.Errorf("invalid categoryId")
+		}
+	}
+	if b.Keyword != nil {
+		webhook.Keyword = *b.Keyword
+	}
+	if b.Active != nil {
+		webhook.Active = *b.Active
+	}
+	return nil
+}
+
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := db.GetAllWebhooks(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get webhooks", http.StatusInternalServerError)
+		return
+	}
+	if hooks == nil {
+		hooks = []db.Webhook{}
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var reqData webhookBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	if reqData.URL == nil {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+
+	webhook := db.Webhook{Active: true}
+	if err := reqData.apply(&webhook); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := db.CreateWebhook(r.Context(), webhook.URL, webhook.Secret, webhook.RssID, webhook.CategoryID, webhook.Keyword, webhook.Active)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create webhook: %v", err), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := db.GetWebhookByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Webhook %d not found", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var patch webhookBody
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := db.GetWebhookByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Webhook %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	if err := patch.apply(webhook); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.UpdateWebhook(r.Context(), webhook); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update webhook: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	webhook, err = db.GetWebhookByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load updated webhook", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteWebhook(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryLimit(r, 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", db.DeliveryPending, db.DeliverySucceeded, db.DeliveryFailed:
+	default:
+		http.Error(w, fmt.Sprintf("Invalid status %q", status), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.GetWebhookByID(r.Context(), id); err != nil {
+		http.Error(w, fmt.Sprintf("Webhook %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	deliveries, err := db.GetWebhookDeliveries(r.Context(), id, status, limit)
+	if err != nil {
+		http.Error(w, "Failed to get deliveries", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []db.WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := db.GetWebhookDeliveryByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Delivery %d not found", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+func ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := webhooks.Replay(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	delivery, err := db.GetWebhookDeliveryByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to load delivery", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, delivery)
+}
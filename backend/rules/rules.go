@@ -0,0 +1,214 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// Fields a rule can match against
+const (
+	FieldTitle   = "title"
+	FieldContent = "content"
+	FieldAuthor  = "author"
+	FieldLink    = "link"
+	FieldAny     = "any"
+)
+
+// Match types
+const (
+	MatchKeyword = "keyword"
+	MatchRegex   = "regex"
+)
+
+// Actions
+const (
+	ActionSkip     = "skip"
+	ActionMarkRead = "mark_read"
+	ActionStar     = "star"
+	ActionTag      = "tag"
+	ActionWebhook  = "webhook"
+	ActionArchive  = "archive"
+)
+
+// Candidate is an article as seen by the rules engine, either an incoming
+// feed item or a stored article
+type Candidate struct {
+	Title   string
+	Content string
+	Author  string
+	Link    string
+}
+
+// Result is the combined outcome of all rules that matched
+type Result struct {
+	Skip     bool
+	MarkRead bool
+	Star     bool
+	Tags     []string
+	Webhooks []int
+	Archive  bool
+	Matched  []int // IDs of the matching rules
+}
+
+// Validate checks that a rule is well formed, including compiling regexes
+func Validate(rule *db.Rule) error {
+	switch rule.Field {
+	case FieldTitle, FieldContent, FieldAuthor, FieldLink, FieldAny:
+	default:
+		return fmt.Errorf("invalid field %q", rule.Field)
+	}
+
+	if strings.TrimSpace(rule.Pattern) == "" {
+		return fmt.Errorf("pattern is required")
+	}
+
+	switch rule.MatchType {
+	case MatchKeyword:
+	case MatchRegex:
+		// Not cached, patterns being edited or tested may never be saved
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid matchType %q", rule.MatchType)
+	}
+
+	switch rule.Action {
+	case ActionSkip, ActionMarkRead, ActionStar, ActionArchive:
+	case ActionTag:
+		rule.Tag = NormalizeTag(rule.Tag)
+		if rule.Tag == "" {
+			return fmt.Errorf("tag is required for the tag action")
+		}
+	case ActionWebhook:
+		if rule.WebhookID == nil {
+			return fmt.Errorf("webhookId is required for the webhook action")
+		}
+	default:
+		return fmt.Errorf("invalid action %q", rule.Action)
+	}
+
+	return nil
+}
+
+// Evaluate applies rules to a candidate. Rules are expected to already be
+// limited to the candidate's feed (see db.GetRulesForFeed).
+func Evaluate(rules []db.Rule, c Candidate) Result {
+	var result Result
+	for i := range rules {
+		rule := &rules[i]
+		if !Matches(rule, c) {
+			continue
+		}
+
+		result.Matched = append(result.Matched, rule.ID)
+		switch rule.Action {
+		case ActionSkip:
+			result.Skip = true
+		case ActionMarkRead:
+			result.MarkRead = true
+		case ActionStar:
+			result.Star = true
+		case ActionTag:
+			result.Tags = append(result.Tags, rule.Tag)
+		case ActionWebhook:
+			if rule.WebhookID != nil {
+				result.Webhooks = append(result.Webhooks, *rule.WebhookID)
+			}
+		case ActionArchive:
+			result.Archive = true
+		}
+	}
+	return result
+}
+
+// Matches reports whether a single rule matches the candidate
+func Matches(rule *db.Rule, c Candidate) bool {
+	var values []string
+	switch rule.Field {
+	case FieldTitle:
+		values = []string{c.Title}
+	case FieldContent:
+		values = []string{c.Content}
+	case FieldAuthor:
+		values = []string{c.Author}
+	case FieldLink:
+		values = []string{c.Link}
+	case FieldAny:
+		values = []string{c.Title, c.Content, c.Author, c.Link}
+	}
+
+	for _, value := range values {
+		if matchValue(rule, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchValue matches a keyword rule (a comma separated list of keywords,
+// case insensitive) or a regex rule against a single value
+func matchValue(rule *db.Rule, value string) bool {
+	if value == "" {
+		return false
+	}
+
+	switch rule.MatchType {
+	case MatchKeyword:
+		lower := strings.ToLower(value)
+		for _, keyword := range strings.Split(rule.Pattern, ",") {
+			keyword = strings.ToLower(strings.TrimSpace(keyword))
+			if keyword != "" && strings.Contains(lower, keyword) {
+				return true
+			}
+		}
+	case MatchRegex:
+		re, err := compile(rule.Pattern)
+		if err != nil {
+			return false
+		}
+		return re.MatchString(value)
+	}
+	return false
+}
+
+// NormalizeTag trims and lowercases a tag name so "Security " and
+// "security" are the same tag
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// maxCached bounds the regex cache. Saved rules are far fewer; patterns
+// tried through the test endpoint shouldn't make it grow without limit.
+const maxCached = 256
+
+// Compiled regexes are cached by pattern since the same rules run for every
+// item of every feed
+var (
+	cacheMu sync.Mutex
+	cache   = map[string]*regexp.Regexp{}
+)
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	if re, ok := cache[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	// Starting over is cheap, the rules in use are compiled again on the
+	// next item
+	if len(cache) >= maxCached {
+		clear(cache)
+	}
+	cache[pattern] = re
+	return re, nil
+}
@@ -0,0 +1,95 @@
+package rules
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+func TestValidate(t *testing.T) {
+	webhookID := 1
+	tests := []struct {
+		name    string
+		rule    db.Rule
+		wantErr bool
+		wantTag string
+	}{
+		{"keyword skip", db.Rule{Field: FieldTitle, MatchType: MatchKeyword, Pattern: "sponsored", Action: ActionSkip}, false, ""},
+		{"regex", db.Rule{Field: FieldLink, MatchType: MatchRegex, Pattern: "/jobs?/", Action: ActionMarkRead}, false, ""},
+		{"invalid regex", db.Rule{Field: FieldLink, MatchType: MatchRegex, Pattern: "(", Action: ActionSkip}, true, ""},
+		{"invalid field", db.Rule{Field: "body", MatchType: MatchKeyword, Pattern: "x", Action: ActionSkip}, true, ""},
+		{"empty pattern", db.Rule{Field: FieldAny, MatchType: MatchKeyword, Pattern: "  ", Action: ActionSkip}, true, ""},
+		{"invalid action", db.Rule{Field: FieldAny, MatchType: MatchKeyword, Pattern: "x", Action: "delete"}, true, ""},
+		{"tag is normalized", db.Rule{Field: FieldAny, MatchType: MatchKeyword, Pattern: "x", Action: ActionTag, Tag: " Security "}, false, "security"},
+		{"tag without name", db.Rule{Field: FieldAny, MatchType: MatchKeyword, Pattern: "x", Action: ActionTag, Tag: " "}, true, ""},
+		{"webhook", db.Rule{Field: FieldAny, MatchType: MatchKeyword, Pattern: "x", Action: ActionWebhook, WebhookID: &webhookID}, false, ""},
+		{"webhook without id", db.Rule{Field: FieldAny, MatchType: MatchKeyword, Pattern: "x", Action: ActionWebhook}, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(&tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.rule.Tag != tt.wantTag && !tt.wantErr {
+				t.Errorf("Tag = %q, want %q", tt.rule.Tag, tt.wantTag)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	webhookID := 7
+	rules := []db.Rule{
+		{ID: 1, Field: FieldTitle, MatchType: MatchKeyword, Pattern: "sponsored, ad:", Action: ActionSkip},
+		{ID: 2, Field: FieldLink, MatchType: MatchRegex, Pattern: `/jobs?/`, Action: ActionMarkRead},
+		{ID: 3, Field: FieldAny, MatchType: MatchKeyword, Pattern: "CVE-", Action: ActionTag, Tag: "security"},
+		{ID: 4, Field: FieldContent, MatchType: MatchKeyword, Pattern: "exploit", Action: ActionTag, Tag: "to-review"},
+		{ID: 5, Field: FieldAuthor, MatchType: MatchKeyword, Pattern: "alice", Action: ActionStar},
+		{ID: 6, Field: FieldTitle, MatchType: MatchRegex, Pattern: `(?i)^release`, Action: ActionWebhook, WebhookID: &webhookID},
+	}
+
+	tests := []struct {
+		name      string
+		candidate Candidate
+		want      Result
+	}{
+		{"no match", Candidate{Title: "Hello", Link: "https://example.com/post"}, Result{}},
+		{"skip is case insensitive", Candidate{Title: "SPONSORED: a product"}, Result{Skip: true, Matched: []int{1}}},
+		{"regex on link", Candidate{Link: "https://example.com/jobs/42"}, Result{MarkRead: true, Matched: []int{2}}},
+		{"tags from several rules", Candidate{Title: "cve-2024-1 fixed", Content: "no exploit known"},
+			Result{Tags: []string{"security", "to-review"}, Matched: []int{3, 4}}},
+		{"star and webhook", Candidate{Title: "Release 2.0", Author: "Alice"},
+			Result{Star: true, Webhooks: []int{7}, Matched: []int{5, 6}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Evaluate(rules, tt.candidate)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Evaluate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileCacheIsBounded(t *testing.T) {
+	for i := range maxCached * 3 {
+		if _, err := compile(fmt.Sprintf("pattern-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cacheMu.Lock()
+	size := len(cache)
+	cacheMu.Unlock()
+	if size > maxCached {
+		t.Errorf("cache holds %d patterns, want at most %d", size, maxCached)
+	}
+
+	re, err := compile("pattern-1")
+	if err != nil || !re.MatchString("pattern-1") {
+		t.Errorf("compile after eviction = %v, %v", re, err)
+	}
+}
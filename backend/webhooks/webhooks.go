@@ -0,0 +1,274 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JonSchaeffer/go-reader/db"
+)
+
+// Event names sent in the X-GoReader-Event header and payload
+const (
+	EventArticleCreated = "article.created"
+	EventRuleMatched    = "rule.matched"
+)
+
+const (
+	maxAttempts  = 6
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = time.Hour
+	pollInterval = 15 * time.Second
+	batchSize    = 20
+	claimLease   = 5 * time.Minute // Long enough to send a whole batch
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// kick wakes the delivery worker when new deliveries are queued
+var kick = make(chan struct{}, 1)
+
+// Payload is the JSON body POSTed to webhook URLs
+type Payload struct {
+	Event     string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Feed      *db.RSS     `json:"feed"`
+	Article   *db.Article `json:"article"`
+}
+
+// ArticleCreated queues a delivery for every active webhook whose scope
+// matches a newly inserted article
+func ArticleCreated(ctx context.Context, article *db.Article) {
+	webhooks, err := db.GetActiveWebhooks(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading webhooks", "error", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	feed, err := db.GetRSSByID(ctx, article.RssID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading feed for webhooks", "feedId", article.RssID, "error", err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !Matches(&webhook, feed, article) {
+			continue
+		}
+		if err := queue(ctx, webhook.ID, EventArticleCreated, feed, article); err != nil {
+			slog.ErrorContext(ctx, "Error queueing webhook", "webhookId", webhook.ID, "articleId", article.ID, "error", err)
+		}
+	}
+}
+
+// Trigger queues a delivery of an article to a specific webhook regardless
+// of its scope. Used by the rules engine's webhook action.
+func Trigger(ctx context.Context, webhookID int, article *db.Article) error {
+	webhook, err := db.GetWebhookByID(ctx, webhookID)
+	if err != nil {
+		return err
+	}
+	if !webhook.Active {
+		return nil
+	}
+
+	feed, err := db.GetRSSByID(ctx, article.RssID)
+	if err != nil {
+		return err
+	}
+
+	return queue(ctx, webhook.ID, EventRuleMatched, feed, article)
+}
+
+// Matches reports whether an article falls within the webhook's scope. All
+// scope fields that are set must match.
+func Matches(webhook *db.Webhook, feed *db.RSS, article *db.Article) bool {
+	if webhook.RssID != nil && *webhook.RssID != feed.ID {
+		return false
+	}
+	if webhook.CategoryID != nil && (feed.CategoryID == nil || *webhook.CategoryID != *feed.CategoryID) {
+		return false
+	}
+	if webhook.Keyword != "" {
+		keyword := strings.ToLower(webhook.Keyword)
+		if !strings.Contains(strings.ToLower(article.Title), keyword) &&
+			!strings.Contains(strings.ToLower(article.Description), keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+func queue(ctx context.Context, webhookID int, event string, feed *db.RSS, article *db.Article) error {
+	body, err := json.Marshal(Payload{
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Feed:      feed,
+		Article:   article,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.CreateWebhookDelivery(ctx, webhookID, &article.ID, event, body)
+	if err != nil {
+		return err
+	}
+
+	wake()
+	return nil
+}
+
+// Replay queues an existing delivery to be sent again with the same payload
+func Replay(ctx context.Context, deliveryID int) error {
+	if err := db.ResetWebhookDelivery(ctx, deliveryID); err != nil {
+		return err
+	}
+	wake()
+	return nil
+}
+
+func wake() {
+	select {
+	case kick <- struct{}{}:
+	default:
+	}
+}
+
+// StartDeliveryWorker sends queued deliveries until ctx is cancelled. Failed
+// deliveries are retried with exponential backoff up to maxAttempts.
+func StartDeliveryWorker(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	slog.InfoContext(ctx, "Webhook delivery worker started")
+
+	for {
+		deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "Webhook delivery worker stopping")
+			return
+		case <-ticker.C:
+		case <-kick:
+		}
+	}
+}
+
+func deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := db.ClaimDueWebhookDeliveries(ctx, batchSize, claimLease)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error loading webhook deliveries", "error", err)
+			return
+		}
+
+		for _, delivery := range deliveries {
+			deliver(ctx, delivery)
+		}
+
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+func deliver(ctx context.Context, delivery db.WebhookDelivery) {
+	webhook, err := db.GetWebhookByID(ctx, delivery.WebhookID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading webhook", "webhookId", delivery.WebhookID, "error", err)
+		return
+	}
+
+	start := time.Now()
+	statusCode, err := send(ctx, webhook, delivery)
+	duration := time.Since(start)
+
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+
+	if err == nil {
+		if err := db.RecordWebhookAttempt(ctx, delivery.ID, code, "", duration, db.DeliverySucceeded, 0); err != nil {
+			slog.ErrorContext(ctx, "Error recording webhook delivery", "deliveryId", delivery.ID, "error", err)
+		}
+		return
+	}
+
+	attempt := delivery.Attempts + 1
+	status := db.DeliveryPending
+	var retryIn time.Duration
+	if attempt >= maxAttempts {
+		status = db.DeliveryFailed
+		slog.ErrorContext(ctx, "Webhook delivery failed, giving up", "deliveryId", delivery.ID, "url", webhook.URL, "attempts", attempt, "error", err)
+	} else {
+		retryIn = backoff(attempt)
+		slog.WarnContext(ctx, "Webhook delivery failed, retrying", "deliveryId", delivery.ID, "url", webhook.URL,
+			"attempt", attempt, "retryIn", retryIn, "error", err)
+	}
+
+	if err := db.RecordWebhookAttempt(ctx, delivery.ID, code, err.Error(), duration, status, retryIn); err != nil {
+		slog.ErrorContext(ctx, "Error recording webhook delivery", "deliveryId", delivery.ID, "error", err)
+	}
+}
+
+// backoff returns the wait before the retry following the given attempt
+func backoff(attempt int) time.Duration {
+	wait := baseBackoff << (attempt - 1)
+	if wait > maxBackoff || wait <= 0 {
+		return maxBackoff
+	}
+	return wait
+}
+
+// send POSTs the delivery's payload and returns the response status code.
+// Any non-2xx response is an error.
+func send(ctx context.Context, webhook *db.Webhook, delivery db.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-reader-webhooks")
+	req.Header.Set("X-GoReader-Event", delivery.Event)
+	req.Header.Set("X-GoReader-Delivery", strconv.Itoa(delivery.ID))
+	if webhook.Secret != "" {
+		req.Header.Set("X-GoReader-Signature", "sha256="+Sign(webhook.Secret, delivery.Payload))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body using secret. Receivers
+// verify the X-GoReader-Signature header by computing the same value.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}